@@ -0,0 +1,95 @@
+package graceful
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Environment variables set by the service manager for socket activation
+// see http://0pointer.de/blog/projects/socket-activation.html
+const (
+	// EnvListenPID holds the PID of the process the sockets are meant for
+	EnvListenPID string = "LISTEN_PID"
+	// EnvListenFDs holds the number of sockets passed to the process
+	EnvListenFDs string = "LISTEN_FDS"
+	// EnvListenFDNames holds the colon separated names of the sockets, if any
+	EnvListenFDNames string = "LISTEN_FDNAMES"
+)
+
+// listenFDsStart is the first file descriptor passed by the service manager
+var listenFDsStart int = 3
+
+// unixPrefix is the prefix of the addresses of Unix domain sockets (e.g. "unix:/run/app.sock")
+const unixPrefix string = "unix:"
+
+// ActivationListeners returns the listeners passed by the service manager
+// following the LISTEN_FDS/LISTEN_PID convention, or nil if there are none
+// The environment variables are unset, so that they are not inherited by child processes
+func ActivationListeners() ([]net.Listener, error) {
+	pid, ok := os.LookupEnv(EnvListenPID)
+	if !ok {
+		return nil, nil
+	}
+	nfds := os.Getenv(EnvListenFDs)
+
+	for _, key := range []string{EnvListenPID, EnvListenFDs, EnvListenFDNames} {
+		if err := os.Unsetenv(key); err != nil {
+			return nil, err
+		}
+	}
+
+	// the sockets are meant for another process
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(nfds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid %s value: %q", EnvListenFDs, nfds)
+	}
+
+	listeners := make([]net.Listener, 0, n)
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("activation-fd-%d", fd))
+
+		// FileListener duplicates the file descriptor, so f can be closed
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+
+		listeners = append(listeners, ln)
+	}
+
+	return listeners, nil
+}
+
+// listen returns the first listener passed by the service manager, if any,
+// otherwise it starts listening on addr
+// addr is either a TCP address or the path of a Unix domain socket prefixed with "unix:"
+func listen(addr string) (net.Listener, error) {
+	listeners, err := ActivationListeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(listeners) > 0 {
+		// only a single socket is supported
+		for _, ln := range listeners[1:] {
+			ln.Close()
+		}
+		return listeners[0], nil
+	}
+
+	if strings.HasPrefix(addr, unixPrefix) {
+		return net.Listen("unix", strings.TrimPrefix(addr, unixPrefix))
+	}
+
+	return net.Listen("tcp", addr)
+}
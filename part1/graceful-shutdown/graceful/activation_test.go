@@ -0,0 +1,127 @@
+package graceful
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestActivationListeners(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// ActivationListeners takes ownership of the descriptor
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate the service manager passing a single socket
+	start := listenFDsStart
+	listenFDsStart = fd
+	defer func() { listenFDsStart = start }()
+
+	os.Setenv(EnvListenPID, strconv.Itoa(os.Getpid()))
+	os.Setenv(EnvListenFDs, "1")
+
+	listeners, err := ActivationListeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(listeners) != 1 {
+		t.Fatalf("expected 1 listener, got %d\n", len(listeners))
+	}
+	defer listeners[0].Close()
+
+	if listeners[0].Addr().String() != ln.Addr().String() {
+		t.Fatalf("expected listener on %s, got %s\n", ln.Addr(), listeners[0].Addr())
+	}
+
+	for _, key := range []string{EnvListenPID, EnvListenFDs} {
+		if _, ok := os.LookupEnv(key); ok {
+			t.Fatalf("expected %s to be removed from the environment\n", key)
+		}
+	}
+}
+
+func TestActivationListenersOtherProcess(t *testing.T) {
+	os.Setenv(EnvListenPID, strconv.Itoa(os.Getpid()+1))
+	os.Setenv(EnvListenFDs, "1")
+
+	listeners, err := ActivationListeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(listeners) != 0 {
+		t.Fatalf("expected no listeners, got %d\n", len(listeners))
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graceful")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "server.sock")
+
+	srv := NewServer(unixPrefix+sock, Options{})
+	srvErrs := srv.Run()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", sock)
+			},
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	// wait for the server to listen on the socket
+	for !srv.Ready() {
+		select {
+		case err := <-srvErrs:
+			t.Fatal(err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	res, err := client.Get("http://unix/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d\n", http.StatusOK, res.StatusCode)
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// srvErrs should have been closed without errors
+	for err := range srvErrs {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,111 @@
+package graceful
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// connContextKey is the key of the underlying connection in the request context
+type connContextKey struct{}
+
+// withConn stores the underlying connection in the connection context
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// request holds the information about an in-flight request
+type request struct {
+	route  string
+	start  time.Time
+	conn   net.Conn
+	cancel context.CancelFunc
+}
+
+// inflight is a registry of the requests being served
+type inflight struct {
+	mu   sync.Mutex
+	reqs map[*request]struct{}
+}
+
+func newInflight() *inflight {
+	return &inflight{
+		reqs: make(map[*request]struct{}),
+	}
+}
+
+// track wraps next to register each request while it is being served
+// The request context is canceled when the request is aborted
+func (i *inflight) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		conn, _ := r.Context().Value(connContextKey{}).(net.Conn)
+		req := &request{
+			route:  r.Method + " " + r.URL.Path,
+			start:  time.Now(),
+			conn:   conn,
+			cancel: cancel,
+		}
+
+		i.mu.Lock()
+		i.reqs[req] = struct{}{}
+		i.mu.Unlock()
+
+		defer func() {
+			i.mu.Lock()
+			delete(i.reqs, req)
+			i.mu.Unlock()
+		}()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// abortAll cancels the context of every in-flight request
+// and then closes its underlying connection
+// It returns the number of aborted requests
+func (i *inflight) abortAll() int {
+	i.mu.Lock()
+	reqs := make([]*request, 0, len(i.reqs))
+	for req := range i.reqs {
+		reqs = append(reqs, req)
+	}
+	i.mu.Unlock()
+
+	// first give the handlers a chance to stop on their own...
+	for _, req := range reqs {
+		req.cancel()
+	}
+
+	// ...then close the connections, to stop the ones not honoring the context
+	for _, req := range reqs {
+		if req.conn != nil {
+			req.conn.Close()
+		}
+		log.Printf("server: aborted request %s after %v\n", req.route, time.Since(req.start))
+	}
+
+	return len(reqs)
+}
+
+// dump writes the list of the in-flight requests to w
+func (i *inflight) dump(w io.Writer) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if len(i.reqs) == 0 {
+		fmt.Fprintln(w, "no in-flight requests")
+		return
+	}
+
+	for req := range i.reqs {
+		fmt.Fprintf(w, "%s (running for %v)\n", req.route, time.Since(req.start))
+	}
+}
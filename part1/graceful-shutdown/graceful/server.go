@@ -4,56 +4,190 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Pippolo84/go-services-patterns/part1/graceful-shutdown/shutdown"
 	"nhooyr.io/websocket"
 )
 
+// Options holds all the configuration options for the Server
+type Options struct {
+	// PreStopDelay is the lame-duck period: during shutdown, the server
+	// reports itself as not ready for this long while still serving traffic,
+	// giving the load balancer time to stop routing requests to it
+	PreStopDelay time.Duration
+
+	// WSCloseTimeout is the maximum time to wait for each websocket
+	// client to complete the close handshake on shutdown
+	WSCloseTimeout time.Duration
+
+	// Listener, if not nil, is used to accept the connections instead of
+	// listening on the server address (e.g. the one returned by Listen,
+	// a Unix domain socket or an in-memory listener)
+	Listener net.Listener
+}
+
+// defaultWSCloseTimeout is used when no websocket close timeout is specified
+const defaultWSCloseTimeout time.Duration = 5 * time.Second
+
 // Server is a HTTP server that supports graceful shutdown
 type Server struct {
 	http.Server
+
+	preStopDelay time.Duration
+	// ready is 1 when the server is accepting traffic, 0 otherwise
+	ready int32
+	// handedOver is 1 when the listener has been passed to a new process
+	handedOver int32
+
+	// listener is the one passed in the Options or, once running, the one opened by Run
+	// It is guarded by mu, since Upgrade can read it while Run sets it
+	mu       sync.Mutex
+	listener net.Listener
+
+	inflight       *inflight
+	sessions       *sessions
+	wsCloseTimeout time.Duration
 }
 
 // NewServer returns a reference to a new Server listening on addr
-func NewServer(addr string) *Server {
+// and configured with the specified options
+func NewServer(addr string, opts Options) *Server {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/", handler)
-	mux.HandleFunc("/slow", slowHandler)
-	// see https://github.com/hashrocket/ws for a websocket client
-	mux.HandleFunc("/ws", wsHandler)
+	wsCloseTimeout := opts.WSCloseTimeout
+	if wsCloseTimeout <= 0 {
+		wsCloseTimeout = defaultWSCloseTimeout
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
 	srv := &Server{
+		preStopDelay:   opts.PreStopDelay,
+		listener:       opts.Listener,
+		inflight:       newInflight(),
+		sessions:       newSessions(),
+		wsCloseTimeout: wsCloseTimeout,
 		Server: http.Server{
 			Addr:    addr,
 			Handler: mux,
-			// use the context with cancellation to be able to gracefully shutdown websocket connections
-			BaseContext: func(_ net.Listener) context.Context {
-				return ctx
-			},
+			// keep a reference to the connection, to be able to close it
+			// if the request outlives the shutdown grace period
+			ConnContext: withConn,
 		},
 	}
 
-	// on shutdown, cancel the context
-	srv.Server.RegisterOnShutdown(cancel)
+	// track the in-flight requests, to abort them on shutdown if needed
+	mux.Handle("/", srv.inflight.track(http.HandlerFunc(handler)))
+	mux.Handle("/slow", srv.inflight.track(http.HandlerFunc(slowHandler)))
+
+	// websocket sessions are tracked and closed on their own
+	// see https://github.com/hashrocket/ws for a websocket client
+	mux.HandleFunc("/ws", srv.wsHandler)
+
+	// liveness and readiness probes
+	mux.Handle("/healthz", srv.inflight.track(http.HandlerFunc(healthzHandler)))
+	mux.Handle("/readyz", srv.inflight.track(http.HandlerFunc(srv.readyzHandler)))
 
 	return srv
 }
 
+// Ready reports whether the server is ready to accept traffic
+func (srv *Server) Ready() bool {
+	return atomic.LoadInt32(&srv.ready) == 1
+}
+
+// SessionsReport returns the outcome of closing the websocket sessions
+// during the last shutdown
+func (srv *Server) SessionsReport() SessionsReport {
+	return srv.sessions.lastReport()
+}
+
+// DumpInFlight writes the list of the in-flight requests to w
+func (srv *Server) DumpInFlight(w io.Writer) {
+	srv.inflight.dump(w)
+}
+
+// RegisterShutdownHooks registers the server shutdown steps in the coordinator:
+// - the lame-duck phase, in which the readiness probe fails while the server
+// keeps serving traffic for the configured pre-stop delay
+// - the drain of the in-flight HTTP requests, aborting the ones that
+// outlive the phase deadline
+// - the close of the websocket sessions, with a StatusGoingAway close frame
+func (srv *Server) RegisterShutdownHooks(c *shutdown.Coordinator) {
+	c.Register(shutdown.StopAccepting, "lame-duck", func(ctx context.Context) error {
+		srv.lameDuck(ctx)
+		return nil
+	})
+
+	c.Register(shutdown.DrainHTTP, "http", srv.drain)
+
+	// hijacked connections are not tracked by the HTTP server,
+	// so the websocket sessions must be closed on their own
+	c.Register(shutdown.CloseStreams, "websocket", func(ctx context.Context) error {
+		report := srv.sessions.closeAll(ctx, srv.wsCloseTimeout)
+		log.Printf("server: websocket sessions closed cleanly: %d, forced: %d\n", report.Clean, report.Forced)
+		return nil
+	})
+}
+
 // Shutdown makes the server stop listening and refuse further connections
-// It takes a context to limit the shutdown duration and a wait group to signal
-// the caller when the shutdown process has finished
-func (srv *Server) Shutdown(ctx context.Context, wg *sync.WaitGroup) error {
-	defer wg.Done()
-	return srv.Server.Shutdown(ctx)
+// It runs all the steps registered by RegisterShutdownHooks, using the default phase budgets
+// It takes a context to limit the shutdown duration
+func (srv *Server) Shutdown(ctx context.Context) error {
+	c := shutdown.NewCoordinator()
+	srv.RegisterShutdownHooks(c)
+
+	return c.Shutdown(ctx)
+}
+
+// drain stops the server and waits for the in-flight requests to complete
+// When ctx is done, the requests still running are forcibly aborted
+// and all the remaining connections are closed
+func (srv *Server) drain(ctx context.Context) error {
+	err := srv.Server.Shutdown(ctx)
+	if err != nil {
+		if n := srv.inflight.abortAll(); n > 0 {
+			log.Printf("server: %d request(s) aborted\n", n)
+		}
+
+		// close the connections not serving any request, too
+		// (hijacked connections are not affected)
+		_ = srv.Server.Close()
+	}
+
+	return err
+}
+
+// lameDuck flips the readiness to failing and waits for the pre-stop delay
+// or for the context to be done, whichever comes first
+func (srv *Server) lameDuck(ctx context.Context) {
+	atomic.StoreInt32(&srv.ready, 0)
+
+	if srv.preStopDelay <= 0 || atomic.LoadInt32(&srv.handedOver) == 1 {
+		return
+	}
+
+	log.Printf("server: lame-duck phase, waiting %v before shutdown\n", srv.preStopDelay)
+
+	timer := time.NewTimer(srv.preStopDelay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
 }
 
 // Run starts the server, making it listening on specified address
+// The address is either a TCP address or the path of a Unix domain socket
+// prefixed with "unix:". If the process has been socket activated, the socket
+// passed by the service manager is used instead, as well as the listener
+// specified in the Options, if any.
 // it returns a channel where all errors are relayed
 func (srv *Server) Run() <-chan error {
 	errs := make(chan error)
@@ -61,9 +195,25 @@ func (srv *Server) Run() <-chan error {
 	go func() {
 		defer close(errs)
 
-		log.Printf("server: start listening on %s\n", srv.Addr)
+		srv.mu.Lock()
+		ln := srv.listener
+		if ln == nil {
+			var err error
+			if ln, err = listen(srv.Addr); err != nil {
+				srv.mu.Unlock()
+				errs <- err
+				return
+			}
+			// keep it, to hand it over on Upgrade
+			srv.listener = ln
+		}
+		srv.mu.Unlock()
+
+		log.Printf("server: start listening on %s\n", ln.Addr())
+
+		atomic.StoreInt32(&srv.ready, 1)
 
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errs <- err
 		}
 
@@ -77,16 +227,35 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Hello, world!")
 }
 
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "ok")
+}
+
+func (srv *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if !srv.Ready() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintf(w, "ok")
+}
+
 func slowHandler(w http.ResponseWriter, r *http.Request) {
 	// Shutdown won't close this connection until it returns to idle
-	time.Sleep(10 * time.Second)
+	// or it is aborted at the end of the grace period
+	select {
+	case <-time.After(10 * time.Second):
+	case <-r.Context().Done():
+		return
+	}
 	fmt.Fprintf(w, "Hello, slow world!")
 }
 
-func wsHandler(w http.ResponseWriter, r *http.Request) {
+func (srv *Server) wsHandler(w http.ResponseWriter, r *http.Request) {
 	c, err := websocket.Accept(w, r, nil)
 	if err != nil {
 		fmt.Printf("websocket upgrade error: %v\n", err)
+		return
 	}
 	// Additional calls to Close are no-ops
 	defer c.Close(websocket.StatusInternalError, "internal server error")
@@ -95,6 +264,13 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), time.Second*10)
 	defer cancel()
 
+	// track the session to close it gracefully on shutdown
+	if !srv.sessions.add(c, cancel) {
+		c.Close(websocket.StatusGoingAway, goingAwayReason)
+		return
+	}
+	defer srv.sessions.remove(c)
+
 	if err := c.Write(ctx, websocket.MessageText, []byte("Hello, ws world!")); err != nil {
 		if !errors.Is(err, context.Canceled) {
 			log.Printf("ws write error: %v\n", err)
@@ -104,7 +280,7 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 
 	mtype, buf, err := c.Read(ctx)
 	if err != nil {
-		if !errors.Is(err, context.Canceled) {
+		if !errors.Is(err, context.Canceled) && websocket.CloseStatus(err) != websocket.StatusGoingAway {
 			log.Printf("ws read error: %v\n", err)
 		}
 		return
@@ -8,21 +8,19 @@ import (
 	"net"
 	"net/http"
 	"strings"
-	"sync"
 	"testing"
 	"time"
 
 	"go.uber.org/goleak"
+	"nhooyr.io/websocket"
 )
 
-// FIXME: add a test for the ws handler
-
 // go test -v -timeout=20s .
 
-func startServer(t *testing.T, addr string) (*Server, <-chan error) {
+func startServer(t *testing.T, addr string, opts Options) (*Server, <-chan error) {
 	t.Helper()
 
-	srv := NewServer(addr)
+	srv := NewServer(addr, opts)
 
 	errs := make(chan error)
 
@@ -54,7 +52,7 @@ func startServer(t *testing.T, addr string) (*Server, <-chan error) {
 func TestGetRequest(t *testing.T) {
 	defer goleak.VerifyNone(t)
 
-	srv, srvErrs := startServer(t, ":8080")
+	srv, srvErrs := startServer(t, ":8080", Options{})
 
 	res, err := http.Get("http://localhost:8080")
 	if err != nil {
@@ -66,15 +64,10 @@ func TestGetRequest(t *testing.T) {
 		t.Fatalf("expected status code %d, got %d\n", http.StatusOK, res.StatusCode)
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-
-	if err := srv.Shutdown(context.Background(), &wg); err != nil {
+	if err := srv.Shutdown(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 
-	wg.Wait()
-
 	// srvErrs should have been closed without errors
 	for err := range srvErrs {
 		t.Fatal(err)
@@ -84,20 +77,15 @@ func TestGetRequest(t *testing.T) {
 func TestGracefulShutdown(t *testing.T) {
 	defer goleak.VerifyNone(t)
 
-	srv, srvErrs := startServer(t, ":8080")
+	srv, srvErrs := startServer(t, ":8080", Options{})
 
 	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancelShutdown()
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-
-	if err := srv.Shutdown(shutdownCtx, &wg); err != nil {
+	if err := srv.Shutdown(shutdownCtx); err != nil {
 		t.Fatal(err)
 	}
 
-	wg.Wait()
-
 	// srvErrs should have been closed without errors
 	for err := range srvErrs {
 		t.Fatal(err)
@@ -122,22 +110,17 @@ func slowRequest(t *testing.T, addr string) <-chan error {
 }
 
 func TestGracefulShutdownTimeout(t *testing.T) {
-	srv, srvErrs := startServer(t, ":8081")
+	srv, srvErrs := startServer(t, ":8081", Options{})
 
 	reqErrs := slowRequest(t, "http://localhost:8081/slow")
 
 	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), time.Second)
 	defer cancelShutdown()
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-
-	if err := srv.Shutdown(shutdownCtx, &wg); !errors.Is(err, context.DeadlineExceeded) {
+	if err := srv.Shutdown(shutdownCtx); !errors.Is(err, context.DeadlineExceeded) {
 		t.Fatalf("expected context deadline exceeded, got: %v\n", err)
 	}
 
-	wg.Wait()
-
 	// srvErrs and reqErrs should have been closed without errors
 	for err := range srvErrs {
 		t.Fatal(err)
@@ -146,3 +129,189 @@ func TestGracefulShutdownTimeout(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestLameDuck(t *testing.T) {
+	// ignore the connections left open by TestGracefulShutdownTimeout
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	srv, srvErrs := startServer(t, ":8082", Options{
+		PreStopDelay: 2 * time.Second,
+	})
+
+	getStatus := func(url string) int {
+		t.Helper()
+
+		res, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		return res.StatusCode
+	}
+
+	if code := getStatus("http://localhost:8082/readyz"); code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d\n", http.StatusOK, code)
+	}
+
+	shutdownErrs := make(chan error, 1)
+	go func() {
+		shutdownErrs <- srv.Shutdown(context.Background())
+	}()
+
+	// wait for the lame-duck phase to start
+	for srv.Ready() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// during the lame-duck phase the server must fail the readiness probe
+	// while still serving both the liveness probe and the regular traffic
+	if code := getStatus("http://localhost:8082/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status code %d, got %d\n", http.StatusServiceUnavailable, code)
+	}
+	if code := getStatus("http://localhost:8082/healthz"); code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d\n", http.StatusOK, code)
+	}
+	if code := getStatus("http://localhost:8082/"); code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d\n", http.StatusOK, code)
+	}
+
+	if err := <-shutdownErrs; err != nil {
+		t.Fatal(err)
+	}
+
+	// srvErrs should have been closed without errors
+	for err := range srvErrs {
+		t.Fatal(err)
+	}
+}
+
+func dialWebSocket(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// wait for the greeting, so that the session is surely registered
+	if _, _, err := c.Read(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestWebSocketGoingAway(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	srv, srvErrs := startServer(t, ":8083", Options{})
+
+	c := dialWebSocket(t, "ws://localhost:8083/ws")
+
+	// the client keeps reading to answer the close handshake
+	closeErrs := make(chan error, 1)
+	go func() {
+		_, _, err := c.Read(context.Background())
+		closeErrs <- err
+	}()
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	err := <-closeErrs
+	if code := websocket.CloseStatus(err); code != websocket.StatusGoingAway {
+		t.Fatalf("expected close status %v, got %v (%v)\n", websocket.StatusGoingAway, code, err)
+	}
+
+	if report := srv.SessionsReport(); report.Clean != 1 || report.Forced != 0 {
+		t.Fatalf("expected 1 clean and 0 forced sessions, got %+v\n", report)
+	}
+
+	// srvErrs should have been closed without errors
+	for err := range srvErrs {
+		t.Fatal(err)
+	}
+}
+
+func TestWebSocketForcedClose(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	srv, srvErrs := startServer(t, ":8084", Options{
+		WSCloseTimeout: 500 * time.Millisecond,
+	})
+
+	// the client never reads again, so the close handshake can't complete
+	c := dialWebSocket(t, "ws://localhost:8084/ws")
+	defer c.Close(websocket.StatusNormalClosure, "")
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if report := srv.SessionsReport(); report.Clean != 0 || report.Forced != 1 {
+		t.Fatalf("expected 0 clean and 1 forced sessions, got %+v\n", report)
+	}
+
+	// srvErrs should have been closed without errors
+	for err := range srvErrs {
+		t.Fatal(err)
+	}
+}
+
+func TestAbortInFlightRequests(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	srv, srvErrs := startServer(t, ":8086", Options{})
+
+	reqErrs := make(chan error, 1)
+	go func() {
+		res, err := http.Get("http://localhost:8086/slow")
+		if err == nil {
+			res.Body.Close()
+		}
+		reqErrs <- err
+	}()
+
+	inFlight := func() int {
+		srv.inflight.mu.Lock()
+		defer srv.inflight.mu.Unlock()
+
+		return len(srv.inflight.reqs)
+	}
+
+	// wait for the slow request to be served
+	for inFlight() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), time.Second)
+	defer cancelShutdown()
+
+	if err := srv.Shutdown(shutdownCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context deadline exceeded, got: %v\n", err)
+	}
+
+	// the request must be aborted well before the slow handler completes
+	select {
+	case err := <-reqErrs:
+		if err == nil {
+			t.Fatal("expected the slow request to be aborted")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow request still running after the shutdown grace period")
+	}
+
+	for inFlight() != 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// srvErrs should have been closed without errors
+	for err := range srvErrs {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,137 @@
+package graceful
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// goingAwayReason is the reason sent to the clients in the close frame on shutdown
+const goingAwayReason string = "server shutting down"
+
+// SessionsReport holds the outcome of closing the websocket sessions on shutdown
+type SessionsReport struct {
+	// Clean is the number of sessions that completed the close handshake
+	Clean int
+	// Forced is the number of sessions whose connection was forcibly closed
+	// because the peer didn't complete the close handshake in time
+	Forced int
+}
+
+// sessions is a registry of the accepted websocket connections
+type sessions struct {
+	mu      sync.Mutex
+	conns   map[*websocket.Conn]context.CancelFunc
+	closing bool
+	report  SessionsReport
+}
+
+func newSessions() *sessions {
+	return &sessions{
+		conns: make(map[*websocket.Conn]context.CancelFunc),
+	}
+}
+
+// add registers a new websocket connection along with the function to cancel
+// the context used by its handler
+// It returns false if the registry is closing and the session should not be started
+func (s *sessions) add(c *websocket.Conn, cancel context.CancelFunc) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return false
+	}
+
+	s.conns[c] = cancel
+	return true
+}
+
+// remove unregisters a websocket connection
+func (s *sessions) remove(c *websocket.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, c)
+}
+
+// closeAll sends a StatusGoingAway close frame to every registered connection
+// and waits up to timeout for each close handshake to complete.
+// Connections that overrun the timeout or the context deadline are forcibly closed.
+// Note that the websocket library never waits more than 5 seconds for the peer.
+func (s *sessions) closeAll(ctx context.Context, timeout time.Duration) SessionsReport {
+	s.mu.Lock()
+	s.closing = true
+	conns := make(map[*websocket.Conn]context.CancelFunc, len(s.conns))
+	for c, cancel := range s.conns {
+		conns[c] = cancel
+	}
+	s.mu.Unlock()
+
+	var (
+		report SessionsReport
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+	)
+
+	wg.Add(len(conns))
+	for c, cancel := range conns {
+		go func(c *websocket.Conn, cancel context.CancelFunc) {
+			defer wg.Done()
+
+			clean := closeSession(ctx, c, cancel, timeout)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if clean {
+				report.Clean++
+			} else {
+				report.Forced++
+			}
+		}(c, cancel)
+	}
+
+	wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.report = report
+
+	return report
+}
+
+// lastReport returns the outcome of the last call to closeAll
+func (s *sessions) lastReport() SessionsReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.report
+}
+
+// closeSession performs the close handshake on c, reporting whether it completed in time
+func closeSession(ctx context.Context, c *websocket.Conn, cancel context.CancelFunc, timeout time.Duration) bool {
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Close(websocket.StatusGoingAway, goingAwayReason)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err == nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	// canceling the handler context makes the library drop the underlying connection
+	cancel()
+	<-done
+
+	return false
+}
@@ -0,0 +1,205 @@
+package graceful
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Environment variables used to hand the listening socket over to the new process
+// during a binary upgrade
+const (
+	// EnvListenerFD holds the file descriptor of the inherited listener
+	EnvListenerFD string = "GRACEFUL_LISTENER_FD"
+	// EnvReadyFD holds the file descriptor used to signal readiness to the parent
+	EnvReadyFD string = "GRACEFUL_READY_FD"
+)
+
+// ErrUpgradeTimeout is returned when the new process doesn't signal its readiness in time
+var ErrUpgradeTimeout error = errors.New("upgrade: timeout waiting for the new process")
+
+// ErrNotUpgradable is returned when the listener can't be handed over to another process
+var ErrNotUpgradable error = errors.New("upgrade: server has no listener exposing its file descriptor")
+
+// filer is implemented by the listeners that can be handed over to another process,
+// like *net.TCPListener and *net.UnixListener
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Listen returns the listener inherited from the parent process, if any,
+// otherwise the socket passed by the service manager, if any.
+// As a last resort, it starts listening on addr, that is either a TCP address
+// or the path of a Unix domain socket prefixed with "unix:"
+func Listen(addr string) (net.Listener, error) {
+	fd, ok, err := inheritedFD(EnvListenerFD)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return listen(addr)
+	}
+
+	f := os.NewFile(fd, "inherited-listener")
+	defer f.Close()
+
+	// FileListener duplicates the file descriptor, so f can be closed
+	return net.FileListener(f)
+}
+
+// NotifyReady signals the parent process that the new process is ready to serve
+// It is a no-op if the process has not been started by Upgrade
+func NotifyReady() error {
+	fd, ok, err := inheritedFD(EnvReadyFD)
+	if err != nil || !ok {
+		return err
+	}
+
+	f := os.NewFile(fd, "ready-pipe")
+	defer f.Close()
+
+	_, err = f.Write([]byte{1})
+	return err
+}
+
+// Upgrade re-executes the running binary, passing the server listener
+// to the new process through an inherited file descriptor.
+// It blocks until the new process signals its readiness with NotifyReady,
+// it exits or the timeout expires.
+// The listener is the one specified in the Options or, if none, the one
+// opened by Run: the server must be running in that case.
+// On success, the caller is expected to gracefully shut down the server:
+// the lame-duck phase is skipped, since the new process is already serving
+// on the same socket. For the same reason, the path of a Unix domain socket
+// is not removed anymore when the listener is closed.
+func (srv *Server) Upgrade(timeout time.Duration) (*os.Process, error) {
+	srv.mu.Lock()
+	fl, ok := srv.listener.(filer)
+	srv.mu.Unlock()
+	if !ok {
+		return nil, ErrNotUpgradable
+	}
+
+	proc, err := startProcess(fl, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// the listener created by net.Listen removes the socket path on Close,
+	// but the new process is serving on it now
+	if ul, ok := fl.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+
+	atomic.StoreInt32(&srv.handedOver, 1)
+
+	return proc, nil
+}
+
+func startProcess(fl filer, timeout time.Duration) (*os.Process, error) {
+	lnFile, err := fl.File()
+	if err != nil {
+		return nil, err
+	}
+	defer lnFile.Close()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyR.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		readyW.Close()
+		return nil, err
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// ExtraFiles entry i becomes file descriptor 3+i in the new process
+	cmd.ExtraFiles = []*os.File{lnFile, readyW}
+	cmd.Env = append(
+		filterEnv(os.Environ(), EnvListenerFD, EnvReadyFD),
+		fmt.Sprintf("%s=%d", EnvListenerFD, 3),
+		fmt.Sprintf("%s=%d", EnvReadyFD, 4),
+	)
+
+	err = cmd.Start()
+	// the child has its own copy of the write end: close ours to detect its exit
+	readyW.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		if _, err := readyR.Read(buf); err != nil {
+			ready <- fmt.Errorf("upgrade: new process exited before being ready: %w", err)
+			return
+		}
+		ready <- nil
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			_ = cmd.Wait()
+			return nil, err
+		}
+	case <-timer.C:
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, ErrUpgradeTimeout
+	}
+
+	return cmd.Process, nil
+}
+
+// inheritedFD reads a file descriptor number from the environment variable key
+func inheritedFD(key string) (uintptr, bool, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return 0, false, nil
+	}
+
+	// don't let the descriptor leak to other processes started from this one
+	if err := os.Unsetenv(key); err != nil {
+		return 0, false, err
+	}
+
+	fd, err := strconv.Atoi(value)
+	if err != nil || fd < 3 {
+		return 0, false, fmt.Errorf("invalid file descriptor in %s: %q", key, value)
+	}
+
+	return uintptr(fd), true, nil
+}
+
+func filterEnv(env []string, keys ...string) []string {
+	filtered := make([]string, 0, len(env))
+
+next:
+	for _, kv := range env {
+		for _, key := range keys {
+			if strings.HasPrefix(kv, key+"=") {
+				continue next
+			}
+		}
+		filtered = append(filtered, kv)
+	}
+
+	return filtered
+}
@@ -0,0 +1,214 @@
+package graceful
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// envUpgradeHelper makes the test binary act as the new process started by Upgrade
+const envUpgradeHelper string = "GRACEFUL_UPGRADE_HELPER"
+
+func TestListenInherited(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Listen takes ownership of the descriptor
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a process started by Upgrade
+	if err := os.Setenv(EnvListenerFD, strconv.Itoa(fd)); err != nil {
+		t.Fatal(err)
+	}
+
+	inherited, err := Listen("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inherited.Close()
+
+	if inherited.Addr().String() != ln.Addr().String() {
+		t.Fatalf("expected inherited listener on %s, got %s\n", ln.Addr(), inherited.Addr())
+	}
+
+	if _, ok := os.LookupEnv(EnvListenerFD); ok {
+		t.Fatalf("expected %s to be removed from the environment\n", EnvListenerFD)
+	}
+}
+
+func TestNotifyReady(t *testing.T) {
+	// without an inherited pipe, NotifyReady is a no-op
+	if err := NotifyReady(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	// NotifyReady takes ownership of the descriptor
+	fd, err := syscall.Dup(int(w.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a process started by Upgrade
+	if err := os.Setenv(EnvReadyFD, strconv.Itoa(fd)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NotifyReady(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1)
+	if _, err := r.Read(buf); err != nil {
+		t.Fatalf("expected readiness notification, got: %v\n", err)
+	}
+}
+
+func TestUpgradeWithoutListener(t *testing.T) {
+	srv := NewServer(":8085", Options{})
+
+	if _, err := srv.Upgrade(0); err != ErrNotUpgradable {
+		t.Fatalf("expected %v, got: %v\n", ErrNotUpgradable, err)
+	}
+}
+
+func TestUpgradableWithRunListener(t *testing.T) {
+	srv := NewServer("localhost:0", Options{})
+	srvErrs := srv.Run()
+
+	// wait for the server to listen
+	for !srv.Ready() {
+		select {
+		case err := <-srvErrs:
+			t.Fatal(err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// the listener opened by Run can be handed over as well
+	srv.mu.Lock()
+	_, ok := srv.listener.(filer)
+	srv.mu.Unlock()
+	if !ok {
+		t.Fatalf("expected the listener opened by Run to be upgradable, got %T\n", srv.listener)
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for err := range srvErrs {
+		t.Fatal(err)
+	}
+}
+
+// TestUpgradeHelperProcess is not a real test: it's the new process
+// started by Upgrade in TestUpgradeUnixSocket
+func TestUpgradeHelperProcess(t *testing.T) {
+	if os.Getenv(envUpgradeHelper) == "" {
+		t.Skip("not started by Upgrade")
+	}
+
+	ln, err := Listen("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	if err := NotifyReady(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("upgraded\n")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpgradeUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.sock")
+
+	srv := NewServer("unix:"+path, Options{})
+	srvErrs := srv.Run()
+
+	// wait for the server to listen
+	for !srv.Ready() {
+		select {
+		case err := <-srvErrs:
+			t.Fatal(err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// the new process is the test binary itself, running only the helper
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{args[0], "-test.run=^TestUpgradeHelperProcess$"}
+
+	if err := os.Setenv(envUpgradeHelper, "1"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv(envUpgradeHelper)
+
+	proc, err := srv.Upgrade(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Kill()
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for err := range srvErrs {
+		t.Fatal(err)
+	}
+
+	// the shutdown of the old process leaves the socket to the new one
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "upgraded\n" {
+		t.Fatalf("expected the new process to serve, got %q\n", line)
+	}
+
+	if _, err := proc.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
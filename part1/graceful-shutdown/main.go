@@ -5,42 +5,103 @@ import (
 	"log"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 	"time"
 
 	"github.com/Pippolo84/go-services-patterns/part1/graceful-shutdown/graceful"
+	"github.com/Pippolo84/go-services-patterns/part1/graceful-shutdown/shutdown"
 )
 
-const cooldown time.Duration = 5 * time.Second
+const (
+	cooldown time.Duration = 5 * time.Second
+
+	// streamsCooldown is the part of the cooldown reserved to close the
+	// websocket sessions, the rest is available to drain the HTTP requests
+	streamsCooldown time.Duration = 1 * time.Second
+
+	// preStopDelay gives the load balancer time to notice the failing
+	// readiness probe before the server stops accepting connections
+	preStopDelay time.Duration = 3 * time.Second
+
+	// watchdogMargin is the extra time given to the shutdown before
+	// dumping the goroutines and exiting hard
+	watchdogMargin time.Duration = 5 * time.Second
+
+	// upgradeTimeout is the maximum time to wait for the upgraded process to be ready
+	upgradeTimeout time.Duration = 10 * time.Second
+)
 
 func main() {
-	srv := graceful.NewServer(":8080")
+	// inherit the listener from the parent process in case of a binary upgrade
+	ln, err := graceful.Listen(":8080")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := graceful.NewServer(":8080", graceful.Options{
+		PreStopDelay: preStopDelay,
+		Listener:     ln,
+	})
 
 	errs := srv.Run()
 
-	// trap incoming SIGINT and SIGKTERM
+	// let the parent process, if any, shut down
+	if err := graceful.NotifyReady(); err != nil {
+		log.Println(err)
+	}
+
+	// trap incoming SIGINT and SIGKTERM, and SIGHUP for binary upgrades
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	// block until a signal or an error from the server is received
-	select {
-	case err := <-errs:
-		log.Println(err)
-	case sig := <-signalChan:
-		log.Printf("got signal: %v, shutting down...\n", sig)
+wait:
+	for {
+		select {
+		case err := <-errs:
+			log.Println(err)
+			break wait
+		case sig := <-signalChan:
+			if sig != syscall.SIGHUP {
+				log.Printf("got signal: %v, shutting down...\n", sig)
+				break wait
+			}
+
+			log.Printf("got signal: %v, upgrading...\n", sig)
+
+			proc, err := srv.Upgrade(upgradeTimeout)
+			if err != nil {
+				// keep serving with the current binary
+				log.Println(err)
+				continue
+			}
+
+			log.Printf("new process %d is ready, shutting down...\n", proc.Pid)
+			break wait
+		}
 	}
 
-	// graceful shutdown the server
-	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cooldown)
+	// graceful shutdown the server, lame-duck phase included
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), preStopDelay+cooldown)
 	defer cancelShutdown()
 
-	var wg sync.WaitGroup
-	wg.Add(1)
+	coordinator := shutdown.NewCoordinator()
+	// the budgets are weights, so the durations can be used as they are:
+	// reserve the whole pre-stop delay to the lame-duck phase,
+	// and most of the cooldown to the drain of the HTTP requests
+	coordinator.SetBudget(shutdown.StopAccepting, preStopDelay.Seconds())
+	coordinator.SetBudget(shutdown.DrainHTTP, (cooldown - streamsCooldown).Seconds())
+	coordinator.SetBudget(shutdown.CloseStreams, streamsCooldown.Seconds())
+
+	srv.RegisterShutdownHooks(coordinator)
 
-	if err := srv.Shutdown(shutdownCtx, &wg); err != nil {
+	// if the shutdown hangs, collect some evidence and exit
+	watchdog := shutdown.NewWatchdog(preStopDelay+cooldown+watchdogMargin, os.Stderr)
+	watchdog.AddDumper("in-flight requests", srv.DumpInFlight)
+	watchdog.Start()
+	defer watchdog.Stop()
+
+	if err := coordinator.Shutdown(shutdownCtx); err != nil {
 		log.Println(err)
 	}
-
-	wg.Wait()
 }
@@ -0,0 +1,327 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Phase is a step of the shutdown process
+// Phases are executed in order, one after the other
+type Phase int
+
+const (
+	// StopAccepting is the phase to stop accepting new work (e.g. the lame-duck period)
+	StopAccepting Phase = iota
+	// DrainHTTP is the phase to wait for the in-flight HTTP requests to complete
+	DrainHTTP
+	// CloseStreams is the phase to close long-lived streams (e.g. websockets)
+	CloseStreams
+	// FlushBackground is the phase to flush the work done in background
+	FlushBackground
+	// CloseStores is the phase to close the stores and free their resources
+	CloseStores
+
+	numPhases int = iota
+)
+
+var phaseNames = [numPhases]string{
+	"stop accepting",
+	"drain HTTP",
+	"close streams",
+	"flush background",
+	"close stores",
+}
+
+// String satisfies the fmt.Stringer interface
+func (p Phase) String() string {
+	if p < 0 || int(p) >= numPhases {
+		return fmt.Sprintf("phase(%d)", int(p))
+	}
+	return phaseNames[p]
+}
+
+// DefaultBudgets are the default weights of the phases in the overall cooldown
+// (see Coordinator.SetBudget)
+var DefaultBudgets = map[Phase]float64{
+	StopAccepting:   0.3,
+	DrainHTTP:       0.3,
+	CloseStreams:    0.2,
+	FlushBackground: 0.1,
+	CloseStores:     0.1,
+}
+
+// Hook is a function called during a shutdown phase
+// It should return as soon as possible after ctx is done
+type Hook func(ctx context.Context) error
+
+type hook struct {
+	name string
+	fn   Hook
+}
+
+// HookError holds the error returned by a hook
+type HookError struct {
+	Phase Phase
+	Name  string
+	Err   error
+	// Elapsed is the time spent by the hook, up to the overall deadline
+	Elapsed time.Duration
+	// Overrun is true if the hook didn't complete within its phase budget
+	Overrun bool
+	// Abandoned is true if the hook was still running at the overall deadline
+	Abandoned bool
+}
+
+// ErrSkipped is the error of the hooks not called because
+// a hook of a previous phase was abandoned
+var ErrSkipped = errors.New("skipped: a previous phase is still running")
+
+// Error satisfies the error interface
+func (e HookError) Error() string {
+	if e.Abandoned {
+		return fmt.Sprintf("%s: %s: abandoned after %v: %v", e.Phase, e.Name, e.Elapsed, e.Err)
+	}
+	if e.Overrun {
+		return fmt.Sprintf("%s: %s: overran its budget, returned after %v: %v", e.Phase, e.Name, e.Elapsed, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", e.Phase, e.Name, e.Err)
+}
+
+// Unwrap returns the error returned by the hook
+func (e HookError) Unwrap() error {
+	return e.Err
+}
+
+// Report is the aggregated error returned by Shutdown
+type Report struct {
+	Errors []HookError
+}
+
+// Error satisfies the error interface
+func (r *Report) Error() string {
+	msgs := make([]string, 0, len(r.Errors))
+	for _, e := range r.Errors {
+		msgs = append(msgs, e.Error())
+	}
+	return fmt.Sprintf("shutdown: %d hook(s) failed: %s", len(r.Errors), strings.Join(msgs, "; "))
+}
+
+// Is reports whether any of the hook errors matches target
+func (r *Report) Is(target error) bool {
+	for _, e := range r.Errors {
+		if errors.Is(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Overruns returns the hooks that didn't complete within their phase budget
+func (r *Report) Overruns() []HookError {
+	var overruns []HookError
+	for _, e := range r.Errors {
+		if e.Overrun {
+			overruns = append(overruns, e)
+		}
+	}
+	return overruns
+}
+
+// Coordinator runs the shutdown hooks registered by the components
+// of a service, phase by phase
+type Coordinator struct {
+	mu      sync.Mutex
+	hooks   [numPhases][]hook
+	budgets [numPhases]float64
+}
+
+// NewCoordinator returns a new Coordinator using the DefaultBudgets
+func NewCoordinator() *Coordinator {
+	c := &Coordinator{}
+	for phase, budget := range DefaultBudgets {
+		c.budgets[phase] = budget
+	}
+
+	return c
+}
+
+// SetBudget sets the weight of phase in the overall cooldown
+// Weights are relative, not fractions of the cooldown: they are normalised
+// over the phases with hooks, so they don't need to sum up to 1
+// Each phase gets the share of the time left given by its weight over the ones
+// of the following phases with hooks, so the time not used by a phase
+// is available to the following ones
+func (c *Coordinator) SetBudget(phase Phase, weight float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.budgets[phase] = weight
+}
+
+// Register adds a hook, identified by name, to be called during phase
+// Hooks in the same phase are called concurrently
+func (c *Coordinator) Register(phase Phase, name string, fn Hook) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hooks[phase] = append(c.hooks[phase], hook{name: name, fn: fn})
+}
+
+// Shutdown runs all the registered hooks, phase by phase.
+// ctx deadline is the overall cooldown: each phase with hooks gets the share of
+// the time left given by its budget, so the time not used by a phase, and the
+// budget of the phases without hooks, is available to the following ones.
+// A hook that doesn't return within its phase deadline is reported as overrun:
+// since the following phases may depend on it, they start only once it returns.
+// If it is still running at the overall deadline, it is abandoned and
+// the hooks of the following phases are skipped.
+// It returns a *Report if any hook fails, nil otherwise.
+func (c *Coordinator) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	hooks := c.hooks
+	budgets := c.budgets
+	c.mu.Unlock()
+
+	deadline, hasDeadline := ctx.Deadline()
+
+	report := &Report{}
+	abandoned := false
+	for phase := 0; phase < numPhases; phase++ {
+		if len(hooks[phase]) == 0 {
+			continue
+		}
+
+		if abandoned {
+			for _, h := range hooks[phase] {
+				report.Errors = append(report.Errors, HookError{
+					Phase: Phase(phase),
+					Name:  h.name,
+					Err:   ErrSkipped,
+				})
+			}
+			continue
+		}
+
+		phaseCtx, cancel := ctx, context.CancelFunc(func() {})
+		if hasDeadline {
+			var total float64
+			for next := phase; next < numPhases; next++ {
+				if len(hooks[next]) > 0 {
+					total += budgets[next]
+				}
+			}
+
+			share := 1.0
+			if total > 0 {
+				share = budgets[phase] / total
+			}
+
+			now := time.Now()
+			phaseCtx, cancel = context.WithDeadline(ctx, now.Add(time.Duration(float64(deadline.Sub(now))*share)))
+		}
+
+		errs := runPhase(ctx, phaseCtx, Phase(phase), hooks[phase])
+		for _, err := range errs {
+			if err.Abandoned {
+				abandoned = true
+			}
+		}
+		report.Errors = append(report.Errors, errs...)
+
+		cancel()
+	}
+
+	if len(report.Errors) > 0 {
+		return report
+	}
+
+	return nil
+}
+
+// runPhase calls concurrently all the hooks of a phase, with phaseCtx,
+// waiting for them up to the ctx deadline
+func runPhase(ctx, phaseCtx context.Context, phase Phase, hooks []hook) []HookError {
+	var (
+		errs []HookError
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+	)
+
+	wg.Add(len(hooks))
+	for _, h := range hooks {
+		go func(h hook) {
+			defer wg.Done()
+
+			if err := runHook(ctx, phaseCtx, phase, h); err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+
+				errs = append(errs, *err)
+			}
+		}(h)
+	}
+
+	wg.Wait()
+
+	return errs
+}
+
+// runHook calls a single hook with phaseCtx
+// If the hook overruns the phaseCtx deadline, it waits for it up to the ctx deadline
+func runHook(ctx, phaseCtx context.Context, phase Phase, h hook) *HookError {
+	start := time.Now()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- h.fn(phaseCtx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-phaseCtx.Done():
+		// the hook may have returned right at the deadline
+		select {
+		case err = <-done:
+		default:
+			return overrun(ctx, phaseCtx, phase, h, start, done)
+		}
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	return &HookError{
+		Phase:   phase,
+		Name:    h.name,
+		Err:     err,
+		Elapsed: time.Since(start),
+	}
+}
+
+// overrun waits for a hook that overran its phase deadline, up to the ctx deadline
+func overrun(ctx, phaseCtx context.Context, phase Phase, h hook, start time.Time, done <-chan error) *HookError {
+	hookErr := &HookError{
+		Phase:   phase,
+		Name:    h.name,
+		Overrun: true,
+	}
+
+	select {
+	case hookErr.Err = <-done:
+	case <-ctx.Done():
+		hookErr.Abandoned = true
+	}
+
+	if hookErr.Err == nil {
+		hookErr.Err = phaseCtx.Err()
+	}
+	hookErr.Elapsed = time.Since(start)
+
+	return hookErr
+}
@@ -0,0 +1,226 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPhasesOrder(t *testing.T) {
+	c := NewCoordinator()
+
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	record := func(name string) Hook {
+		return func(_ context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			order = append(order, name)
+			return nil
+		}
+	}
+
+	// register in reverse order
+	c.Register(CloseStores, "store", record("store"))
+	c.Register(FlushBackground, "flush", record("flush"))
+	c.Register(CloseStreams, "streams", record("streams"))
+	c.Register(DrainHTTP, "http", record("http"))
+	c.Register(StopAccepting, "lame-duck", record("lame-duck"))
+
+	if err := c.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"lame-duck", "http", "streams", "flush", "store"}
+	if len(order) != len(expected) {
+		t.Fatalf("expected %d hooks called, got %d\n", len(expected), len(order))
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("expected hook %q at position %d, got %q\n", expected[i], i, order[i])
+		}
+	}
+}
+
+func TestOverrun(t *testing.T) {
+	c := NewCoordinator()
+	c.SetBudget(DrainHTTP, 0.1)
+	c.SetBudget(CloseStores, 0.9)
+
+	// a hook that ignores the context
+	stuckDone := make(chan struct{})
+	c.Register(DrainHTTP, "stuck", func(_ context.Context) error {
+		defer close(stuckDone)
+
+		time.Sleep(300 * time.Millisecond)
+		return nil
+	})
+
+	var afterStuck bool
+	c.Register(CloseStores, "store", func(_ context.Context) error {
+		select {
+		case <-stuckDone:
+			afterStuck = true
+		default:
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err := c.Shutdown(ctx)
+
+	var report *Report
+	if !errors.As(err, &report) {
+		t.Fatalf("expected a shutdown report, got: %v\n", err)
+	}
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context deadline exceeded, got: %v\n", err)
+	}
+
+	overruns := report.Overruns()
+	if len(overruns) != 1 || overruns[0].Name != "stuck" || overruns[0].Phase != DrainHTTP {
+		t.Fatalf("expected the stuck hook to overrun, got: %v\n", overruns)
+	}
+	if overruns[0].Abandoned || overruns[0].Elapsed < 300*time.Millisecond {
+		t.Fatalf("expected the stuck hook to be waited for, got: %v\n", overruns[0])
+	}
+
+	// the following phases must run anyway, once the stuck hook returns
+	if !afterStuck {
+		t.Fatal("expected the store to be closed after the stuck hook returned")
+	}
+}
+
+func TestAbandoned(t *testing.T) {
+	c := NewCoordinator()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	c.Register(DrainHTTP, "stuck", func(_ context.Context) error {
+		<-release
+		return nil
+	})
+
+	storeClosed := false
+	c.Register(CloseStores, "store", func(_ context.Context) error {
+		storeClosed = true
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	err := c.Shutdown(ctx)
+
+	var report *Report
+	if !errors.As(err, &report) {
+		t.Fatalf("expected a shutdown report, got: %v\n", err)
+	}
+
+	if storeClosed {
+		t.Fatal("expected the store not to be closed while the stuck hook is running")
+	}
+
+	if len(report.Errors) != 2 {
+		t.Fatalf("expected 2 errors, got: %v\n", report.Errors)
+	}
+	if e := report.Errors[0]; e.Name != "stuck" || !e.Overrun || !e.Abandoned {
+		t.Fatalf("expected the stuck hook to be abandoned, got: %v\n", e)
+	}
+	if e := report.Errors[1]; e.Name != "store" || !errors.Is(e, ErrSkipped) {
+		t.Fatalf("expected the store hook to be skipped, got: %v\n", e)
+	}
+}
+
+func TestHookDeadlineExceeded(t *testing.T) {
+	c := NewCoordinator()
+
+	// e.g. a call to a remote store timing out on its own
+	c.Register(CloseStores, "store", func(_ context.Context) error {
+		return context.DeadlineExceeded
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err := c.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context deadline exceeded, got: %v\n", err)
+	}
+
+	var report *Report
+	if !errors.As(err, &report) {
+		t.Fatalf("expected a shutdown report, got: %v\n", err)
+	}
+
+	if len(report.Overruns()) != 0 {
+		t.Fatalf("expected no overruns, got: %v\n", report.Overruns())
+	}
+}
+
+func TestBudgets(t *testing.T) {
+	c := NewCoordinator()
+
+	var stopDeadline, drainDeadline time.Time
+	c.Register(StopAccepting, "lame-duck", func(ctx context.Context) error {
+		stopDeadline, _ = ctx.Deadline()
+		return nil
+	})
+	c.Register(DrainHTTP, "http", func(ctx context.Context) error {
+		drainDeadline, _ = ctx.Deadline()
+		return nil
+	})
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := c.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline, _ := ctx.Deadline()
+
+	// the phases without hooks hold back no time: the two phases,
+	// with the same budget, split the cooldown
+	if d := stopDeadline.Sub(start); d < 450*time.Millisecond || d > 550*time.Millisecond {
+		t.Fatalf("expected the first phase to get half the cooldown, got %v\n", d)
+	}
+
+	// the time not used by the first phase is available to the last one
+	if d := deadline.Sub(drainDeadline); d > 50*time.Millisecond {
+		t.Fatalf("expected the last phase to get the time left, got %v less\n", d)
+	}
+}
+
+func TestHookError(t *testing.T) {
+	c := NewCoordinator()
+
+	errStore := errors.New("store error")
+	c.Register(CloseStores, "store", func(_ context.Context) error {
+		return errStore
+	})
+
+	err := c.Shutdown(context.Background())
+	if !errors.Is(err, errStore) {
+		t.Fatalf("expected %v, got: %v\n", errStore, err)
+	}
+
+	var report *Report
+	if !errors.As(err, &report) {
+		t.Fatalf("expected a shutdown report, got: %v\n", err)
+	}
+
+	if len(report.Overruns()) != 0 {
+		t.Fatalf("expected no overruns, got: %v\n", report.Overruns())
+	}
+}
@@ -0,0 +1,102 @@
+package shutdown
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+// ExitCodeStuck is the exit code used by the Watchdog when the shutdown overruns
+const ExitCodeStuck int = 3
+
+// Dumper writes diagnostic information to w (e.g. the list of in-flight requests)
+type Dumper func(w io.Writer)
+
+type section struct {
+	name string
+	dump Dumper
+}
+
+// Watchdog exits the process if the shutdown doesn't complete in time,
+// after writing a full goroutine dump and the registered diagnostic sections
+// to its output, to help finding what blocked the shutdown
+type Watchdog struct {
+	timeout time.Duration
+	out     io.Writer
+
+	mu       sync.Mutex
+	sections []section
+	timer    *time.Timer
+
+	// exit is called to terminate the process, replaced in tests
+	exit func(code int)
+}
+
+// NewWatchdog returns a new Watchdog that fires after timeout and writes to out
+// If out is nil, os.Stderr is used
+func NewWatchdog(timeout time.Duration, out io.Writer) *Watchdog {
+	if out == nil {
+		out = os.Stderr
+	}
+
+	return &Watchdog{
+		timeout: timeout,
+		out:     out,
+		exit:    os.Exit,
+	}
+}
+
+// AddDumper registers a diagnostic section to write when the watchdog fires
+func (wd *Watchdog) AddDumper(name string, dump Dumper) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	wd.sections = append(wd.sections, section{name: name, dump: dump})
+}
+
+// Start arms the watchdog
+func (wd *Watchdog) Start() {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	wd.timer = time.AfterFunc(wd.timeout, wd.fire)
+}
+
+// Stop disarms the watchdog
+// It returns false if the watchdog has already fired
+func (wd *Watchdog) Stop() bool {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	if wd.timer == nil {
+		return true
+	}
+
+	return wd.timer.Stop()
+}
+
+func (wd *Watchdog) fire() {
+	wd.mu.Lock()
+	sections := wd.sections
+	wd.mu.Unlock()
+
+	fmt.Fprintf(wd.out, "shutdown watchdog: shutdown still running after %v, exiting\n", wd.timeout)
+
+	for _, s := range sections {
+		fmt.Fprintf(wd.out, "\n=== %s ===\n", s.name)
+		s.dump(wd.out)
+	}
+
+	fmt.Fprintf(wd.out, "\n=== goroutines ===\n")
+	// debug level 2 prints the stacks in the same format of an unrecovered panic
+	_ = pprof.Lookup("goroutine").WriteTo(wd.out, 2)
+
+	if f, ok := wd.out.(*os.File); ok {
+		_ = f.Sync()
+	}
+
+	wd.exit(ExitCodeStuck)
+}
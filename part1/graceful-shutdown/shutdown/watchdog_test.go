@@ -0,0 +1,87 @@
+package shutdown
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestWatchdogFires(t *testing.T) {
+	var out syncBuffer
+
+	wd := NewWatchdog(100*time.Millisecond, &out)
+
+	codes := make(chan int, 1)
+	wd.exit = func(code int) {
+		codes <- code
+	}
+
+	wd.AddDumper("in-flight requests", func(w io.Writer) {
+		fmt.Fprintln(w, "GET /slow")
+	})
+	wd.Start()
+
+	select {
+	case code := <-codes:
+		if code != ExitCodeStuck {
+			t.Fatalf("expected exit code %d, got %d\n", ExitCodeStuck, code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchdog didn't fire")
+	}
+
+	dump := out.String()
+	for _, expected := range []string{"=== in-flight requests ===", "GET /slow", "=== goroutines ===", "goroutine "} {
+		if !strings.Contains(dump, expected) {
+			t.Fatalf("expected %q in the dump, got:\n%s\n", expected, dump)
+		}
+	}
+
+	if wd.Stop() {
+		t.Fatal("expected Stop to report the watchdog already fired")
+	}
+}
+
+func TestWatchdogStopped(t *testing.T) {
+	var out syncBuffer
+
+	wd := NewWatchdog(100*time.Millisecond, &out)
+	wd.exit = func(code int) {
+		t.Errorf("unexpected exit with code %d\n", code)
+	}
+
+	wd.Start()
+	if !wd.Stop() {
+		t.Fatal("expected the watchdog to be stopped before firing")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if out.String() != "" {
+		t.Fatalf("expected no output, got:\n%s\n", out.String())
+	}
+}
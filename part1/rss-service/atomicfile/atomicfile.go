@@ -0,0 +1,49 @@
+// Package atomicfile replaces files atomically: after a crash
+// a file holds either its previous content or the new one, never a mix
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Write writes buf to a temporary file in the same directory of path,
+// then renames it to path
+// Both the data and the rename are synced to disk before returning
+func Write(path string, buf []byte) error {
+	dir := filepath.Dir(path)
+
+	// write a temporary file in the same directory, so that it can be renamed
+	f, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	// the data must be on disk before the rename
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+
+	// and so must be the rename
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,45 @@
+// +build !integration
+
+package atomicfile
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.json")
+
+	for _, content := range []string{"first", "second"} {
+		if err := Write(path, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != content {
+			t.Fatalf("expected %q, got %q\n", content, buf)
+		}
+	}
+
+	// the temporary files are removed
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected only the written file, got %d files\n", len(files))
+	}
+}
+
+func TestWriteMissingDir(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "test.json")
+
+	if err := Write(path, []byte("content")); err == nil {
+		t.Fatal("expected an error writing in a missing directory")
+	}
+}
@@ -3,6 +3,7 @@ module github.com/Pippolo84/go-services-patterns/rss-service
 go 1.15
 
 require (
+	github.com/Pippolo84/go-services-patterns/part1/timeouts/server v0.0.0-00010101000000-000000000000
 	github.com/PuerkitoBio/goquery v1.6.0 // indirect
 	github.com/andybalholm/cascadia v1.2.0 // indirect
 	github.com/gorilla/mux v1.8.0
@@ -12,6 +13,10 @@ require (
 	github.com/modern-go/reflect2 v1.0.1 // indirect
 	go.uber.org/goleak v1.1.10
 	golang.org/x/lint v0.0.0-20200302205851-738671d3881b // indirect
-	golang.org/x/net v0.0.0-20201010224723-4f7140c49acb // indirect
+	golang.org/x/net v0.0.0-20201010224723-4f7140c49acb
+	golang.org/x/text v0.3.3
 	golang.org/x/tools v0.0.0-20201010145503-6e5c6d77ddcc // indirect
 )
+
+// the sse package is shared with the timeouts server
+replace github.com/Pippolo84/go-services-patterns/part1/timeouts/server => ../timeouts/server
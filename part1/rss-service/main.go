@@ -1,5 +1,18 @@
 package main
 
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Pippolo84/go-services-patterns/rss-service/service"
+)
+
 // Some feed to use as tests:
 //
 // http://joeroganexp.joerogan.libsynpro.com/rss
@@ -7,6 +20,47 @@ package main
 // https://feeds.npr.org/510312/podcast.xml
 // https://feeds.megaphone.fm/ADL9840290619
 
+const (
+	// shutdownTimeout is the maximum time to wait for the service to shut down
+	shutdownTimeout time.Duration = 5 * time.Second
+)
+
 func main() {
-	// your code here
+	dataDir := flag.String("data", ".", "directory where the service keeps its state")
+	flag.Parse()
+
+	svc := service.NewService(":8080", service.Options{DataDir: *dataDir})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	errs := svc.Run(&wg)
+
+	// wait service init phase
+	wg.Wait()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err, ok := <-errs:
+		if ok {
+			log.Fatal(err)
+		}
+		return
+	case <-stop:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	wg.Add(1)
+	if err := svc.Shutdown(ctx, &wg); err != nil {
+		log.Println(err)
+	}
+	wg.Wait()
+
+	for err := range errs {
+		log.Println(err)
+	}
 }
@@ -0,0 +1,164 @@
+package rss
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// DefaultFeedTimeout is the default deadline to fetch each aggregated feed
+const DefaultFeedTimeout time.Duration = 10 * time.Second
+
+// Source is a feed to aggregate
+type Source struct {
+	Name string
+	URL  string
+}
+
+// Entry is an item of an aggregated timeline, with the name of its feed
+type Entry struct {
+	Feed string
+	Item *gofeed.Item
+}
+
+// Time returns the publication time of the entry, its update time if not published,
+// the zero time if the feed doesn't tell
+func (e Entry) Time() time.Time {
+	switch {
+	case e.Item.PublishedParsed != nil:
+		return *e.Item.PublishedParsed
+	case e.Item.UpdatedParsed != nil:
+		return *e.Item.UpdatedParsed
+	default:
+		return time.Time{}
+	}
+}
+
+// FeedError is the error fetching a single aggregated feed
+type FeedError struct {
+	Name string
+	URL  string
+	Err  error
+}
+
+func (e *FeedError) Error() string {
+	return fmt.Sprintf("rss: feed %s (%s): %v", e.Name, e.URL, e.Err)
+}
+
+// Unwrap returns the error of the fetch
+func (e *FeedError) Unwrap() error {
+	return e.Err
+}
+
+// Timeline holds the items of the aggregated feeds, newest first,
+// and the errors of the feeds that could not be fetched
+type Timeline struct {
+	Entries []Entry
+	Errors  []*FeedError
+}
+
+// AggregatorOptions holds the configuration of an Aggregator
+// The zero value of each field selects its default
+type AggregatorOptions struct {
+	// Concurrency is the maximum number of feeds fetched at the same time
+	Concurrency int
+	// FeedTimeout is the deadline to fetch each feed
+	FeedTimeout time.Duration
+}
+
+// Aggregator fetches many feeds concurrently and merges their items in a single timeline
+// A slow or failing feed never holds back the others: its items are missing
+// from the timeline and its error is reported
+// It is safe to use concurrently
+type Aggregator struct {
+	fetcher Fetcher
+	opts    AggregatorOptions
+}
+
+// NewAggregator returns an Aggregator fetching the feeds with fetcher
+func NewAggregator(fetcher Fetcher, opts AggregatorOptions) *Aggregator {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	if opts.FeedTimeout <= 0 {
+		opts.FeedTimeout = DefaultFeedTimeout
+	}
+
+	return &Aggregator{
+		fetcher: fetcher,
+		opts:    opts,
+	}
+}
+
+// Forget drops what the fetcher remembers about the feed at url, if anything,
+// e.g. the items of the last fetch once the feed is not aggregated anymore
+func (a *Aggregator) Forget(url string) {
+	if f, ok := a.fetcher.(Forgetter); ok {
+		f.Forget(url)
+	}
+}
+
+// Aggregate fetches the sources and returns their timeline
+// The entries without a date follow the dated ones, in the order of the sources,
+// and the errors are in the order of the sources as well
+func (a *Aggregator) Aggregate(ctx context.Context, sources []Source) Timeline {
+	type result struct {
+		items []*gofeed.Item
+		err   error
+	}
+	results := make([]result, len(sources))
+
+	slots := make(chan struct{}, a.opts.Concurrency)
+
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func(i int, src Source) {
+			defer wg.Done()
+
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				results[i].err = ctx.Err()
+				return
+			}
+			defer func() { <-slots }()
+
+			fetchCtx, cancel := context.WithTimeout(ctx, a.opts.FeedTimeout)
+			defer cancel()
+
+			results[i].items, results[i].err = a.fetcher.FetchWithContext(fetchCtx, src.URL)
+		}(i, src)
+	}
+	wg.Wait()
+
+	var tl Timeline
+	for i, res := range results {
+		if res.err != nil {
+			tl.Errors = append(tl.Errors, &FeedError{
+				Name: sources[i].Name,
+				URL:  sources[i].URL,
+				Err:  res.err,
+			})
+			continue
+		}
+
+		for _, item := range res.items {
+			tl.Entries = append(tl.Entries, Entry{Feed: sources[i].Name, Item: item})
+		}
+	}
+
+	sort.SliceStable(tl.Entries, func(i, j int) bool {
+		ti, tj := tl.Entries[i].Time(), tl.Entries[j].Time()
+		if ti.IsZero() || tj.IsZero() {
+			return !ti.IsZero() && tj.IsZero()
+		}
+		return ti.After(tj)
+	})
+
+	return tl
+}
@@ -0,0 +1,98 @@
+// +build !integration
+
+package rss
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestAggregator(t *testing.T) {
+	at := func(day int) *time.Time {
+		t := time.Date(2020, time.December, day, 10, 0, 0, 0, time.UTC)
+		return &t
+	}
+
+	inner := &funcFetcher{
+		fetch: func(ctx context.Context, url string, n int) ([]*gofeed.Item, error) {
+			switch url {
+			case "a-url":
+				return []*gofeed.Item{
+					{GUID: "a1", PublishedParsed: at(1)},
+					{GUID: "a3", PublishedParsed: at(3)},
+					{GUID: "a-undated"},
+				}, nil
+			case "b-url":
+				return []*gofeed.Item{
+					{GUID: "b2", UpdatedParsed: at(2)},
+					{GUID: "b4", PublishedParsed: at(4)},
+				}, nil
+			case "slow-url":
+				<-ctx.Done()
+				return nil, ctx.Err()
+			default:
+				return nil, &StatusError{URL: url, StatusCode: http.StatusNotFound}
+			}
+		},
+	}
+	aggregator := NewAggregator(inner, AggregatorOptions{FeedTimeout: 50 * time.Millisecond})
+
+	start := time.Now()
+	tl := aggregator.Aggregate(context.Background(), []Source{
+		{Name: "a", URL: "a-url"},
+		{Name: "slow", URL: "slow-url"},
+		{Name: "b", URL: "b-url"},
+		{Name: "missing", URL: "missing-url"},
+	})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the slow feed to time out, took %v\n", elapsed)
+	}
+
+	expected := []string{"b4", "a3", "b2", "a1", "a-undated"}
+	if len(tl.Entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %d\n", len(expected), len(tl.Entries))
+	}
+	for i, guid := range expected {
+		if tl.Entries[i].Item.GUID != guid {
+			t.Fatalf("expected entry %d to be %s, got %s\n", i, guid, tl.Entries[i].Item.GUID)
+		}
+	}
+	if tl.Entries[0].Feed != "b" {
+		t.Fatalf("expected the newest entry from feed b, got %s\n", tl.Entries[0].Feed)
+	}
+
+	if len(tl.Errors) != 2 {
+		t.Fatalf("expected 2 errors, got %d\n", len(tl.Errors))
+	}
+	if tl.Errors[0].Name != "slow" || !errors.Is(tl.Errors[0], context.DeadlineExceeded) {
+		t.Fatalf("expected the slow feed to time out, got %v\n", tl.Errors[0])
+	}
+	var se *StatusError
+	if tl.Errors[1].Name != "missing" || !errors.As(tl.Errors[1], &se) {
+		t.Fatalf("expected the missing feed to fail, got %v\n", tl.Errors[1])
+	}
+}
+
+func TestAggregatorConcurrency(t *testing.T) {
+	cf := &countingFetcher{delay: 20 * time.Millisecond}
+	aggregator := NewAggregator(cf, AggregatorOptions{Concurrency: 2})
+
+	sources := make([]Source, 6)
+	for i := range sources {
+		sources[i] = Source{Name: string(rune('a' + i)), URL: "test-url"}
+	}
+
+	tl := aggregator.Aggregate(context.Background(), sources)
+
+	if len(tl.Errors) != 0 {
+		t.Fatalf("expected no errors, got %v\n", tl.Errors)
+	}
+	if cf.calls != 6 || cf.max != 2 {
+		t.Fatalf("expected 6 fetches, 2 at most at the same time, got %d fetches, %d at the same time\n", cf.calls, cf.max)
+	}
+}
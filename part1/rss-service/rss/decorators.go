@@ -0,0 +1,340 @@
+package rss
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// ErrBreakerOpen is returned when the circuit breaker of the feed host is open
+var ErrBreakerOpen = errors.New("rss: circuit breaker is open")
+
+// Decorator wraps a Fetcher adding some behavior to it
+type Decorator func(Fetcher) Fetcher
+
+// Chain wraps fetcher with the decorators, the first one being the outermost
+// A sensible order is:
+//
+//	Chain(fetcher, WithBreaker(...), WithRetry(...), WithRateLimit(...), WithTimeout(...))
+//
+// so that a whole series of retries counts as a single failure for the breaker,
+// while each attempt is rate limited and has its own timeout
+func Chain(fetcher Fetcher, decorators ...Decorator) Fetcher {
+	for i := len(decorators) - 1; i >= 0; i-- {
+		fetcher = decorators[i](fetcher)
+	}
+
+	return fetcher
+}
+
+// Strategy is an interface that wraps the BackOff method
+// It has the same method set of the Strategy of the retry package,
+// so its strategies can be used as well
+type Strategy interface {
+	BackOff(n int) time.Duration
+}
+
+// Exponential implements the exponential backoff algorithm
+type Exponential struct {
+	// Quantum is the basic unit of duration for the algorithm
+	Quantum time.Duration
+}
+
+// BackOff satisfies the Strategy interface for the Exponential type
+// it gets the current retry number to return the associated
+// exponential backoff duration
+func (e Exponential) BackOff(n int) time.Duration {
+	return time.Duration(1<<n) * e.Quantum
+}
+
+// temporary reports whether err is a failure of the feed host
+// that may not happen again: timeouts, dropped or refused connections
+// and server errors
+// Any other transport error, e.g. an unsupported scheme, a missing host
+// or an invalid certificate, will happen again and is not retried
+func temporary(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError ||
+			statusErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	// every transport error is a net.Error, since *url.Error is one:
+	// look at what it wraps instead
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EHOSTUNREACH) ||
+		errors.Is(err, syscall.ENETUNREACH)
+}
+
+// host returns the host of the feed at rawURL, used to group the feeds
+func host(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+
+	return u.Host
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// decorated is embedded by the decorators to forward
+// the calls they don't change to the wrapped Fetcher
+type decorated struct {
+	next Fetcher
+}
+
+// Schedule satisfies the Hinter interface, if the wrapped fetcher does
+func (d decorated) Schedule(url string) (Schedule, bool) {
+	h, ok := d.next.(Hinter)
+	if !ok {
+		return Schedule{}, false
+	}
+
+	return h.Schedule(url)
+}
+
+// Forget satisfies the Forgetter interface, if the wrapped fetcher does
+func (d decorated) Forget(url string) {
+	if f, ok := d.next.(Forgetter); ok {
+		f.Forget(url)
+	}
+}
+
+// WithTimeout returns a Decorator limiting each fetch to d
+func WithTimeout(d time.Duration) Decorator {
+	return func(next Fetcher) Fetcher {
+		return &timeoutFetcher{decorated: decorated{next: next}, timeout: d}
+	}
+}
+
+type timeoutFetcher struct {
+	decorated
+	timeout time.Duration
+}
+
+func (tf *timeoutFetcher) FetchWithContext(ctx context.Context, url string) ([]*gofeed.Item, error) {
+	ctx, cancel := context.WithTimeout(ctx, tf.timeout)
+	defer cancel()
+
+	return tf.next.FetchWithContext(ctx, url)
+}
+
+func (tf *timeoutFetcher) Fetch(url string) ([]*gofeed.Item, error) {
+	return tf.FetchWithContext(context.Background(), url)
+}
+
+// WithRetry returns a Decorator retrying the fetches failed for a temporary error
+// (timeouts, connection errors and server errors) up to maxRetries times,
+// waiting the backoff time taken from strategy before each retry
+func WithRetry(strategy Strategy, maxRetries int) Decorator {
+	return func(next Fetcher) Fetcher {
+		return &retryFetcher{decorated: decorated{next: next}, strategy: strategy, maxRetries: maxRetries}
+	}
+}
+
+type retryFetcher struct {
+	decorated
+	strategy   Strategy
+	maxRetries int
+}
+
+func (rf *retryFetcher) FetchWithContext(ctx context.Context, url string) ([]*gofeed.Item, error) {
+	items, err := rf.next.FetchWithContext(ctx, url)
+
+	for i := 0; i < rf.maxRetries && err != nil && temporary(err) && ctx.Err() == nil; i++ {
+		if sleep(ctx, rf.strategy.BackOff(i)) != nil {
+			break
+		}
+
+		items, err = rf.next.FetchWithContext(ctx, url)
+	}
+
+	return items, err
+}
+
+func (rf *retryFetcher) Fetch(url string) ([]*gofeed.Item, error) {
+	return rf.FetchWithContext(context.Background(), url)
+}
+
+// WithBreaker returns a Decorator with a circuit breaker for each feed host
+// After threshold consecutive temporary errors (timeouts, connection errors
+// and server errors) the breaker opens: the fetches from the host fail right away
+// with ErrBreakerOpen for cooldown, then a single fetch probes the host again
+func WithBreaker(threshold int, cooldown time.Duration) Decorator {
+	return func(next Fetcher) Fetcher {
+		return &breakerFetcher{
+			decorated: decorated{next: next},
+			threshold: threshold,
+			cooldown:  cooldown,
+			hosts:     make(map[string]*breaker),
+		}
+	}
+}
+
+// breaker is the circuit breaker state of a host
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+type breakerFetcher struct {
+	decorated
+	threshold int
+	cooldown  time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*breaker
+}
+
+// pre checks the breaker of host before a fetch
+func (bf *breakerFetcher) pre(host string) error {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+
+	b, ok := bf.hosts[host]
+	if !ok {
+		b = &breaker{}
+		bf.hosts[host] = b
+	}
+
+	if b.failures < bf.threshold {
+		// closed
+		return nil
+	}
+
+	// open, or half-open with a probe in flight
+	if b.probing || time.Now().Before(b.openUntil) {
+		return ErrBreakerOpen
+	}
+
+	// half-open: let a single fetch probe the host
+	b.probing = true
+	return nil
+}
+
+// post updates the breaker of host after a fetch ended with err
+// A fetch canceled by the caller says nothing about the host
+func (bf *breakerFetcher) post(host string, err error, canceled bool) {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+
+	b := bf.hosts[host]
+	b.probing = false
+
+	switch {
+	case canceled:
+		return
+	case err == nil || !temporary(err):
+		// the host is working
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= bf.threshold {
+		b.openUntil = time.Now().Add(bf.cooldown)
+	}
+}
+
+func (bf *breakerFetcher) FetchWithContext(ctx context.Context, url string) ([]*gofeed.Item, error) {
+	h := host(url)
+
+	if err := bf.pre(h); err != nil {
+		return nil, err
+	}
+
+	items, err := bf.next.FetchWithContext(ctx, url)
+	bf.post(h, err, ctx.Err() != nil)
+
+	return items, err
+}
+
+func (bf *breakerFetcher) Fetch(url string) ([]*gofeed.Item, error) {
+	return bf.FetchWithContext(context.Background(), url)
+}
+
+// WithRateLimit returns a Decorator starting at most a fetch
+// from each feed host every interval
+// The fetches over the limit wait for their turn
+func WithRateLimit(interval time.Duration) Decorator {
+	return func(next Fetcher) Fetcher {
+		return &limitFetcher{
+			decorated: decorated{next: next},
+			interval:  interval,
+			turns:     make(map[string]time.Time),
+		}
+	}
+}
+
+type limitFetcher struct {
+	decorated
+	interval time.Duration
+
+	mu    sync.Mutex
+	turns map[string]time.Time
+}
+
+// reserve returns the time to wait for the next turn of host
+func (lf *limitFetcher) reserve(host string) time.Duration {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	now := time.Now()
+
+	turn := lf.turns[host]
+	if turn.Before(now) {
+		turn = now
+	}
+	lf.turns[host] = turn.Add(lf.interval)
+
+	return turn.Sub(now)
+}
+
+func (lf *limitFetcher) FetchWithContext(ctx context.Context, url string) ([]*gofeed.Item, error) {
+	if err := sleep(ctx, lf.reserve(host(url))); err != nil {
+		return nil, err
+	}
+
+	return lf.next.FetchWithContext(ctx, url)
+}
+
+func (lf *limitFetcher) Fetch(url string) ([]*gofeed.Item, error) {
+	return lf.FetchWithContext(context.Background(), url)
+}
@@ -0,0 +1,281 @@
+// +build !integration
+
+package rss
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// funcFetcher is a Fetcher calling fetch, counting the calls for each URL
+type funcFetcher struct {
+	fetch func(ctx context.Context, url string, n int) ([]*gofeed.Item, error)
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (ff *funcFetcher) FetchWithContext(ctx context.Context, url string) ([]*gofeed.Item, error) {
+	ff.mu.Lock()
+	if ff.calls == nil {
+		ff.calls = make(map[string]int)
+	}
+	ff.calls[url]++
+	n := ff.calls[url]
+	ff.mu.Unlock()
+
+	return ff.fetch(ctx, url, n)
+}
+
+func (ff *funcFetcher) Fetch(url string) ([]*gofeed.Item, error) {
+	return ff.FetchWithContext(context.Background(), url)
+}
+
+func (ff *funcFetcher) count(url string) int {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	return ff.calls[url]
+}
+
+func TestWithRetry(t *testing.T) {
+	const (
+		flaky  = "http://flaky.example.com/rss"
+		broken = "http://broken.example.com/rss"
+	)
+
+	inner := &funcFetcher{
+		fetch: func(ctx context.Context, url string, n int) ([]*gofeed.Item, error) {
+			switch {
+			case url == broken:
+				return nil, &StatusError{URL: url, StatusCode: http.StatusNotFound}
+			case n < 3:
+				return nil, &StatusError{URL: url, StatusCode: http.StatusServiceUnavailable}
+			default:
+				return []*gofeed.Item{{GUID: "1"}}, nil
+			}
+		},
+	}
+	fetcher := Chain(inner, WithRetry(Exponential{Quantum: time.Millisecond}, 3))
+
+	items, err := fetcher.FetchWithContext(context.Background(), flaky)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || inner.count(flaky) != 3 {
+		t.Fatalf("expected 1 item after 3 fetches, got %d items after %d fetches\n", len(items), inner.count(flaky))
+	}
+
+	// a client error is not retried
+	if _, err := fetcher.FetchWithContext(context.Background(), broken); err == nil {
+		t.Fatal("expected an error")
+	}
+	if inner.count(broken) != 1 {
+		t.Fatalf("expected 1 fetch, got %d\n", inner.count(broken))
+	}
+}
+
+func TestTemporary(t *testing.T) {
+	testCases := []struct {
+		name      string
+		err       error
+		temporary bool
+	}{
+		{
+			name:      "server error",
+			err:       &StatusError{StatusCode: http.StatusBadGateway},
+			temporary: true,
+		},
+		{
+			name:      "client error",
+			err:       &StatusError{StatusCode: http.StatusNotFound},
+			temporary: false,
+		},
+		{
+			name:      "timeout",
+			err:       &url.Error{Op: "Get", URL: "http://example.com", Err: context.DeadlineExceeded},
+			temporary: true,
+		},
+		{
+			name: "connection refused",
+			err: &url.Error{Op: "Get", URL: "http://example.com", Err: &net.OpError{
+				Op:  "dial",
+				Net: "tcp",
+				Err: os.NewSyscallError("connect", syscall.ECONNREFUSED),
+			}},
+			temporary: true,
+		},
+		{
+			name:      "host not found",
+			err:       &url.Error{Op: "Get", URL: "http://example.com", Err: &net.DNSError{Err: "no such host", IsNotFound: true}},
+			temporary: false,
+		},
+		{
+			name:      "unsupported scheme",
+			err:       &url.Error{Op: "Get", URL: "ftp://example.com", Err: errors.New("unsupported protocol scheme \"ftp\"")},
+			temporary: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if temporary := temporary(tc.err); temporary != tc.temporary {
+				t.Fatalf("expected temporary to be %v, got %v\n", tc.temporary, temporary)
+			}
+		})
+	}
+}
+
+func TestWithRetryPermanentTransportError(t *testing.T) {
+	// nothing listens on the port of a closed server
+	ts := httptest.NewServer(http.NotFoundHandler())
+	refused := ts.URL
+	ts.Close()
+
+	unsupported := "ftp://example.com/rss"
+
+	client := NewClient(ClientOptions{})
+	inner := &funcFetcher{
+		fetch: func(ctx context.Context, url string, n int) ([]*gofeed.Item, error) {
+			return client.FetchWithContext(ctx, url)
+		},
+	}
+	fetcher := Chain(inner, WithRetry(Exponential{Quantum: time.Millisecond}, 3))
+
+	if _, err := fetcher.FetchWithContext(context.Background(), unsupported); err == nil {
+		t.Fatal("expected an error")
+	}
+	if inner.count(unsupported) != 1 {
+		t.Fatalf("expected 1 fetch, got %d\n", inner.count(unsupported))
+	}
+
+	// a refused connection is retried instead
+	if _, err := fetcher.FetchWithContext(context.Background(), refused); err == nil {
+		t.Fatal("expected an error")
+	}
+	if inner.count(refused) != 4 {
+		t.Fatalf("expected 4 fetches, got %d\n", inner.count(refused))
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	inner := &funcFetcher{
+		fetch: func(ctx context.Context, url string, n int) ([]*gofeed.Item, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	fetcher := Chain(inner, WithTimeout(20*time.Millisecond))
+
+	start := time.Now()
+	_, err := fetcher.FetchWithContext(context.Background(), "http://slow.example.com/rss")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v\n", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the fetch to time out, took %v\n", elapsed)
+	}
+}
+
+func TestWithBreaker(t *testing.T) {
+	const (
+		down    = "http://down.example.com/rss"
+		healthy = "http://healthy.example.com/rss"
+	)
+
+	var mu sync.Mutex
+	recovered := false
+
+	inner := &funcFetcher{
+		fetch: func(ctx context.Context, url string, n int) ([]*gofeed.Item, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if url == down && !recovered {
+				return nil, &StatusError{URL: url, StatusCode: http.StatusBadGateway}
+			}
+			return nil, nil
+		},
+	}
+	fetcher := Chain(inner, WithBreaker(2, 50*time.Millisecond))
+
+	for i := 0; i < 2; i++ {
+		if _, err := fetcher.FetchWithContext(context.Background(), down); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+
+	// the breaker of the failing host is open, the others are not affected
+	if _, err := fetcher.FetchWithContext(context.Background(), down); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("expected %v, got %v\n", ErrBreakerOpen, err)
+	}
+	if inner.count(down) != 2 {
+		t.Fatalf("expected 2 fetches, got %d\n", inner.count(down))
+	}
+	if _, err := fetcher.FetchWithContext(context.Background(), healthy); err != nil {
+		t.Fatal(err)
+	}
+
+	// after the cooldown, a probe closes the breaker
+	mu.Lock()
+	recovered = true
+	mu.Unlock()
+
+	time.Sleep(60 * time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		if _, err := fetcher.FetchWithContext(context.Background(), down); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if inner.count(down) != 4 {
+		t.Fatalf("expected 4 fetches, got %d\n", inner.count(down))
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	inner := &funcFetcher{
+		fetch: func(ctx context.Context, url string, n int) ([]*gofeed.Item, error) {
+			return nil, nil
+		},
+	}
+	fetcher := Chain(inner, WithRateLimit(30*time.Millisecond))
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := fetcher.FetchWithContext(context.Background(), "http://a.example.com/rss"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Fatalf("expected the fetches from the same host to be spaced, took %v\n", elapsed)
+	}
+
+	// another host has its own limit
+	start = time.Now()
+	if _, err := fetcher.FetchWithContext(context.Background(), "http://b.example.com/rss"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Fatalf("expected the fetch from another host to start right away, took %v\n", elapsed)
+	}
+}
+
+func TestChainHints(t *testing.T) {
+	fetcher := Chain(NewClient(ClientOptions{}), WithBreaker(1, time.Second), WithTimeout(time.Second))
+
+	if _, ok := fetcher.(Hinter); !ok {
+		t.Fatal("expected the decorated client to report the feed hints")
+	}
+}
@@ -0,0 +1,227 @@
+package rss
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Pippolo84/go-services-patterns/rss-service/atomicfile"
+	"github.com/mmcdole/gofeed"
+)
+
+// DefaultRetention is the default time an item is remembered
+// after it disappears from its feed
+const DefaultRetention time.Duration = 90 * 24 * time.Hour
+
+// ItemKey returns a key identifying the item across fetches: its GUID,
+// its link if it has no GUID, otherwise a hash of its title and publication date
+func ItemKey(item *gofeed.Item) string {
+	if item.GUID != "" {
+		return item.GUID
+	}
+	if item.Link != "" {
+		return item.Link
+	}
+
+	sum := sha1.Sum([]byte(item.Title + "\x00" + item.Published))
+	return hex.EncodeToString(sum[:])
+}
+
+// SeenSet remembers the items already seen, to tell the new ones
+// Each item is remembered until it has not been seen for the retention window,
+// so items still published by their feed are never forgotten
+// If it has a path, it can be saved to and loaded from a local file, to survive restarts
+// It is safe to use concurrently
+type SeenSet struct {
+	path      string
+	retention time.Duration
+
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	dirty bool
+}
+
+// NewSeenSet returns an empty SeenSet stored in the file at path, if not empty
+// A retention of zero selects DefaultRetention
+func NewSeenSet(path string, retention time.Duration) *SeenSet {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+
+	return &SeenSet{
+		path:      path,
+		retention: retention,
+		seen:      make(map[string]time.Time),
+	}
+}
+
+// seenKey returns the key of the item of the feed at url in the set
+func seenKey(url string, item *gofeed.Item) string {
+	sum := sha1.Sum([]byte(url + "\x00" + ItemKey(item)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Filter returns the items of the feed at url not seen before
+// The items already seen are remembered for another retention window,
+// while the new ones are not marked as seen: call Mark once they are delivered
+// N.B.: a Client returns the items of an unchanged feed only once, so an item
+// never marked is returned again only if its feed changes or after a restart
+func (s *SeenSet) Filter(url string, items []*gofeed.Item) []*gofeed.Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.expire(now)
+
+	var fresh []*gofeed.Item
+	for _, item := range items {
+		key := seenKey(url, item)
+		if _, ok := s.seen[key]; !ok {
+			fresh = append(fresh, item)
+			continue
+		}
+
+		s.seen[key] = now
+		s.dirty = true
+	}
+
+	return fresh
+}
+
+// Mark marks the items of the feed at url as seen
+func (s *SeenSet) Mark(url string, items []*gofeed.Item) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, item := range items {
+		s.seen[seenKey(url, item)] = now
+		s.dirty = true
+	}
+}
+
+// Len returns the number of items in the set
+func (s *SeenSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.seen)
+}
+
+// expire forgets the items not seen for the retention window
+func (s *SeenSet) expire(now time.Time) {
+	for key, last := range s.seen {
+		if now.Sub(last) > s.retention {
+			delete(s.seen, key)
+			s.dirty = true
+		}
+	}
+}
+
+// Load reads the set from its file, replacing the items in memory
+// A missing file is not an error
+func (s *SeenSet) Load() error {
+	if s.path == "" {
+		return nil
+	}
+
+	buf, err := ioutil.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]time.Time)
+	if err := json.Unmarshal(buf, &seen); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seen = seen
+	s.expire(time.Now())
+
+	return nil
+}
+
+// Save writes the set to its file, if it has changed since the last save
+// The file is replaced atomically, so a crash never leaves it half written
+func (s *SeenSet) Save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+
+	buf, err := json.Marshal(s.seen)
+	if err != nil {
+		return err
+	}
+
+	if err := atomicfile.Write(s.path, buf); err != nil {
+		return err
+	}
+	s.dirty = false
+
+	return nil
+}
+
+// Dedup is a Fetcher returning only the items not seen before
+// It doesn't mark the items as seen by itself: the caller must Mark them
+// once they are delivered, otherwise they are returned again
+type Dedup struct {
+	decorated
+	seen *SeenSet
+}
+
+// NewDedup returns a Dedup fetching the feeds with fetcher
+// and remembering the items in seen
+func NewDedup(fetcher Fetcher, seen *SeenSet) *Dedup {
+	return &Dedup{
+		decorated: decorated{next: fetcher},
+		seen:      seen,
+	}
+}
+
+// FetchWithContext retrieves the RSS items not seen before from a RSS specified by its URL,
+// returning an error if something goes wrong
+// It accepts a context to support cancellation
+func (d *Dedup) FetchWithContext(ctx context.Context, url string) ([]*gofeed.Item, error) {
+	items, err := d.next.FetchWithContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.seen.Filter(url, items), nil
+}
+
+// Fetch retrieves the RSS items not seen before from a RSS specified by its URL,
+// returning an error if something goes wrong
+func (d *Dedup) Fetch(url string) ([]*gofeed.Item, error) {
+	items, err := d.next.Fetch(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.seen.Filter(url, items), nil
+}
+
+// Mark marks the items of the feed at url as seen,
+// so that they are not returned anymore
+func (d *Dedup) Mark(url string, items []*gofeed.Item) {
+	d.seen.Mark(url, items)
+}
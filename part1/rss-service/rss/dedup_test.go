@@ -0,0 +1,136 @@
+// +build !integration
+
+package rss
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestItemKey(t *testing.T) {
+	testCases := []struct {
+		name  string
+		a, b  *gofeed.Item
+		equal bool
+	}{
+		{
+			name:  "same GUID",
+			a:     &gofeed.Item{GUID: "1", Title: "first"},
+			b:     &gofeed.Item{GUID: "1", Title: "first, edited"},
+			equal: true,
+		},
+		{
+			name:  "same link without GUID",
+			a:     &gofeed.Item{Link: "http://example.com/1", Title: "first"},
+			b:     &gofeed.Item{Link: "http://example.com/1", Title: "first, edited"},
+			equal: true,
+		},
+		{
+			name:  "same title published at different times",
+			a:     &gofeed.Item{Title: "weekly news", Published: "Mon, 07 Dec 2020 10:00:00 GMT"},
+			b:     &gofeed.Item{Title: "weekly news", Published: "Mon, 14 Dec 2020 10:00:00 GMT"},
+			equal: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if equal := ItemKey(tc.a) == ItemKey(tc.b); equal != tc.equal {
+				t.Fatalf("expected equal keys to be %v, got %v\n", tc.equal, equal)
+			}
+		})
+	}
+}
+
+func TestSeenSetRetention(t *testing.T) {
+	seen := NewSeenSet("", 50*time.Millisecond)
+
+	items := []*gofeed.Item{{GUID: "1"}, {GUID: "2"}}
+
+	if fresh := seen.Filter("test-url", items); len(fresh) != 2 {
+		t.Fatalf("expected 2 new items, got %d\n", len(fresh))
+	}
+	// the items are new until marked as seen
+	if fresh := seen.Filter("test-url", items); len(fresh) != 2 {
+		t.Fatalf("expected 2 new items, got %d\n", len(fresh))
+	}
+	seen.Mark("test-url", items)
+
+	if fresh := seen.Filter("test-url", items[:1]); len(fresh) != 0 {
+		t.Fatalf("expected no new items, got %d\n", len(fresh))
+	}
+	if fresh := seen.Filter("other-url", items[:1]); len(fresh) != 1 {
+		t.Fatalf("expected the items of other feeds to be new, got %d\n", len(fresh))
+	}
+
+	// the first item is still published, the second one is forgotten
+	time.Sleep(30 * time.Millisecond)
+	seen.Filter("test-url", items[:1])
+	time.Sleep(30 * time.Millisecond)
+
+	fresh := seen.Filter("test-url", items)
+	if len(fresh) != 1 || fresh[0].GUID != "2" {
+		t.Fatalf("expected only the expired item to be new, got %d items\n", len(fresh))
+	}
+}
+
+func TestSeenSetPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.json")
+
+	seen := NewSeenSet(path, 0)
+	seen.Mark("test-url", []*gofeed.Item{{GUID: "1"}, {GUID: "2"}})
+
+	if err := seen.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// after a restart
+	seen = NewSeenSet(path, 0)
+	if err := seen.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if seen.Len() != 2 {
+		t.Fatalf("expected 2 items, got %d\n", seen.Len())
+	}
+
+	fresh := seen.Filter("test-url", []*gofeed.Item{{GUID: "1"}, {GUID: "3"}})
+	if len(fresh) != 1 || fresh[0].GUID != "3" {
+		t.Fatalf("expected only the unseen item to be new, got %d items\n", len(fresh))
+	}
+}
+
+func TestDedup(t *testing.T) {
+	seen := NewSeenSet("", 0)
+	dedup := NewDedup(&countingFetcher{}, seen)
+
+	items, err := dedup.FetchWithContext(context.Background(), "test-url")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("expected 1 item, got %d\n", len(items))
+	}
+
+	// the item hasn't been delivered, so it's fetched again
+	items, err = dedup.FetchWithContext(context.Background(), "test-url")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("expected 1 item, got %d\n", len(items))
+	}
+
+	dedup.Mark("test-url", items)
+
+	items, err = dedup.FetchWithContext(context.Background(), "test-url")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Fatalf("expected no items, got %d\n", len(items))
+	}
+}
@@ -3,12 +3,18 @@
 package rss
 
 import (
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/mmcdole/gofeed"
 )
@@ -70,7 +76,7 @@ func TestClient(t *testing.T) {
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			client := NewClient()
+			client := NewClient(ClientOptions{})
 
 			ts := httptest.NewServer(http.HandlerFunc(tc.handler))
 
@@ -99,3 +105,228 @@ func TestClient(t *testing.T) {
 		})
 	}
 }
+
+func TestClientConditionalGet(t *testing.T) {
+	const etag = `"v1"`
+	const lastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+	var requests, parsed int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		if r.Header.Get("If-None-Match") == etag && r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		parsed++
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+
+		f, err := os.Open("testdata/golden.xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		if _, err := io.Copy(w, f); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	defer ts.Close()
+
+	client := NewClient(ClientOptions{})
+
+	items, err := client.FetchWithContext(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 5 {
+		t.Fatalf("expected 5 items, got %d\n", len(items))
+	}
+
+	items, err = client.FetchWithContext(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Fatalf("expected no items from an unchanged feed, got %d\n", len(items))
+	}
+
+	if requests != 2 || parsed != 1 {
+		t.Fatalf("expected 2 requests and 1 full response, got %d and %d\n", requests, parsed)
+	}
+
+	// a client keeping the items returns them again
+	client = NewClient(ClientOptions{KeepItems: true})
+
+	for i := 0; i < 2; i++ {
+		items, err = client.FetchWithContext(context.Background(), ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 5 {
+			t.Fatalf("expected 5 items, got %d\n", len(items))
+		}
+	}
+
+	if requests != 4 || parsed != 2 {
+		t.Fatalf("expected 4 requests and 2 full responses, got %d and %d\n", requests, parsed)
+	}
+
+	// a forgotten feed is fetched in full again
+	client.Forget(ts.URL)
+
+	if _, err := client.FetchWithContext(context.Background(), ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != 5 || parsed != 3 {
+		t.Fatalf("expected 5 requests and 3 full responses, got %d and %d\n", requests, parsed)
+	}
+}
+
+func TestClientGzip(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			t.Errorf("expected gzip to be accepted, got %q\n", r.Header.Get("Accept-Encoding"))
+		}
+
+		f, err := os.Open("testdata/golden.xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		w.Header().Set("Content-Encoding", "gzip")
+
+		zw := gzip.NewWriter(w)
+		defer zw.Close()
+
+		if _, err := io.Copy(zw, f); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	defer ts.Close()
+
+	items, err := NewClient(ClientOptions{}).FetchWithContext(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 5 {
+		t.Fatalf("expected 5 items, got %d\n", len(items))
+	}
+}
+
+func TestClientBodyTooLarge(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "testdata/golden.xml")
+	}))
+	defer ts.Close()
+
+	client := NewClient(ClientOptions{})
+	client.MaxBodySize = 1024
+
+	if _, err := client.FetchWithContext(context.Background(), ts.URL); !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("expected %v, got %v\n", ErrBodyTooLarge, err)
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ua := r.Header.Get("User-Agent"); ua != "test-agent" {
+			t.Errorf("expected User-Agent %q, got %q\n", "test-agent", ua)
+		}
+		if token := r.Header.Get("X-Api-Token"); token != "secret" {
+			t.Errorf("expected X-Api-Token %q, got %q\n", "secret", token)
+		}
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "password" {
+			t.Errorf("expected basic auth credentials, got %q %q\n", user, password)
+		}
+
+		http.ServeFile(w, r, "testdata/golden.xml")
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(ClientOptions{
+		UserAgent: "test-agent",
+		Header:    http.Header{"X-Api-Token": []string{"secret"}},
+		Credentials: map[string]Credentials{
+			u.Host: {Username: "user", Password: "password"},
+		},
+	})
+
+	items, err := client.Fetch(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 5 {
+		t.Fatalf("expected 5 items, got %d\n", len(items))
+	}
+}
+
+func TestClientProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a proxy receives the absolute URL of the feed
+		if r.URL.String() != "http://feeds.example.com/rss" {
+			t.Errorf("expected the feed URL, got %q\n", r.URL.String())
+		}
+
+		http.ServeFile(w, r, "testdata/golden.xml")
+	}))
+	defer proxy.Close()
+
+	u, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := NewClient(ClientOptions{Proxy: u}).Fetch("http://feeds.example.com/rss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 5 {
+		t.Fatalf("expected 5 items, got %d\n", len(items))
+	}
+}
+
+func TestClientCharset(t *testing.T) {
+	// "Caffè" in ISO-8859-1, declared only in the Content-Type
+	feed := "<?xml version=\"1.0\"?>\n<rss version=\"2.0\"><channel><title>test</title>" +
+		"<item><title>Caff\xe8</title></item></channel></rss>"
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=iso-8859-1")
+		fmt.Fprint(w, feed)
+	}))
+	defer ts.Close()
+
+	items, err := NewClient(ClientOptions{}).Fetch(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Title != "Caffè" {
+		t.Fatalf("expected a single item titled %q, got %+v\n", "Caffè", items)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	_, err := NewClient(ClientOptions{Timeout: 50 * time.Millisecond}).Fetch(ts.URL)
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected a timeout, got %v\n", err)
+	}
+}
@@ -0,0 +1,154 @@
+package rss
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	gofeedrss "github.com/mmcdole/gofeed/rss"
+)
+
+// Custom keys of the polling hints that the universal gofeed.Feed drops
+const (
+	customTTL       = "ttl"
+	customSkipHours = "skipHours"
+	customSkipDays  = "skipDays"
+)
+
+// Schedule holds the polling hints published by a feed
+type Schedule struct {
+	// TTL is the time the feed can be cached before refreshing it (<ttl>)
+	TTL time.Duration
+	// UpdatePeriod is the update period declared with sy:updatePeriod and sy:updateFrequency
+	UpdatePeriod time.Duration
+	// SkipHours are the hours, in GMT, when the feed should not be polled (<skipHours>)
+	SkipHours []int
+	// SkipDays are the days when the feed should not be polled (<skipDays>)
+	SkipDays []time.Weekday
+}
+
+// Interval returns the polling interval suggested by the feed, zero if none
+func (s Schedule) Interval() time.Duration {
+	if s.TTL > 0 {
+		return s.TTL
+	}
+
+	return s.UpdatePeriod
+}
+
+// Next returns the first time, not before t, when the feed can be polled
+func (s Schedule) Next(t time.Time) time.Time {
+	// a week of skipped hours at most, in case the feed skips all of them
+	for i := 0; i < 7*24 && s.skipped(t); i++ {
+		t = t.Truncate(time.Hour).Add(time.Hour)
+	}
+
+	return t
+}
+
+// skipped reports whether the feed should not be polled at t
+func (s Schedule) skipped(t time.Time) bool {
+	t = t.UTC()
+
+	for _, h := range s.SkipHours {
+		if t.Hour() == h {
+			return true
+		}
+	}
+	for _, d := range s.SkipDays {
+		if t.Weekday() == d {
+			return true
+		}
+	}
+
+	return false
+}
+
+// updatePeriods are the periods allowed by the syndication module
+var updatePeriods = map[string]time.Duration{
+	"hourly":  time.Hour,
+	"daily":   24 * time.Hour,
+	"weekly":  7 * 24 * time.Hour,
+	"monthly": 30 * 24 * time.Hour,
+	"yearly":  365 * 24 * time.Hour,
+}
+
+// weekdays maps the days allowed in <skipDays>
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// scheduleOf returns the polling hints of feed
+// Malformed hints are ignored
+func scheduleOf(feed *gofeed.Feed) Schedule {
+	var s Schedule
+
+	if ttl, err := strconv.Atoi(strings.TrimSpace(feed.Custom[customTTL])); err == nil && ttl > 0 {
+		s.TTL = time.Duration(ttl) * time.Minute
+	}
+
+	for _, field := range strings.Split(feed.Custom[customSkipHours], ",") {
+		if h, err := strconv.Atoi(strings.TrimSpace(field)); err == nil && h >= 0 && h < 24 {
+			s.SkipHours = append(s.SkipHours, h)
+		}
+	}
+
+	for _, field := range strings.Split(feed.Custom[customSkipDays], ",") {
+		if d, ok := weekdays[strings.ToLower(strings.TrimSpace(field))]; ok {
+			s.SkipDays = append(s.SkipDays, d)
+		}
+	}
+
+	if sy, ok := feed.Extensions["sy"]; ok {
+		// as per the syndication module, the period defaults
+		// to daily and the frequency to 1
+		period := updatePeriods["daily"]
+		if ext := sy["updatePeriod"]; len(ext) > 0 {
+			if p, ok := updatePeriods[strings.TrimSpace(ext[0].Value)]; ok {
+				period = p
+			}
+		}
+
+		frequency := 1
+		if ext := sy["updateFrequency"]; len(ext) > 0 {
+			if f, err := strconv.Atoi(strings.TrimSpace(ext[0].Value)); err == nil && f > 0 {
+				frequency = f
+			}
+		}
+
+		s.UpdatePeriod = period / time.Duration(frequency)
+	}
+
+	return s
+}
+
+// hintsTranslator translates RSS feeds like the default translator,
+// keeping in Custom the polling hints that the universal feed drops
+type hintsTranslator struct {
+	gofeed.DefaultRSSTranslator
+}
+
+// Translate satisfies the gofeed.Translator interface
+func (t *hintsTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	result, err := t.DefaultRSSTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+
+	rss := feed.(*gofeedrss.Feed)
+	if result.Custom == nil {
+		result.Custom = make(map[string]string)
+	}
+	result.Custom[customTTL] = rss.TTL
+	result.Custom[customSkipHours] = strings.Join(rss.SkipHours, ",")
+	result.Custom[customSkipDays] = strings.Join(rss.SkipDays, ",")
+
+	return result, nil
+}
@@ -0,0 +1,308 @@
+package rss
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+const (
+	// DefaultPollInterval is the polling interval of the feeds without hints
+	DefaultPollInterval time.Duration = 15 * time.Minute
+	// DefaultMaxBackoff is the default maximum interval between the polls of a failing feed
+	DefaultMaxBackoff time.Duration = 6 * time.Hour
+	// DefaultConcurrency is the default maximum number of feeds fetched at the same time
+	DefaultConcurrency int = 4
+)
+
+// Hinter is implemented by the fetchers reporting the polling hints of the feeds
+type Hinter interface {
+	// Schedule returns the polling hints of the feed at url, if known
+	Schedule(url string) (Schedule, bool)
+}
+
+// SchedulerOptions holds the configuration of a Scheduler
+// The zero value of each field selects its default
+type SchedulerOptions struct {
+	// DefaultInterval is the polling interval of the feeds without hints
+	DefaultInterval time.Duration
+	// MinInterval and MaxInterval bound the interval suggested by the feeds,
+	// zero means no bound
+	MinInterval time.Duration
+	MaxInterval time.Duration
+	// Jitter is the maximum fraction of the interval randomly added or removed,
+	// to spread the polls over time
+	Jitter float64
+	// MaxBackoff is the maximum interval between the polls of a failing feed
+	MaxBackoff time.Duration
+	// Concurrency is the maximum number of feeds fetched at the same time
+	Concurrency int
+}
+
+// Update holds the items fetched from a feed
+type Update struct {
+	Name  string
+	URL   string
+	Items []*gofeed.Item
+}
+
+// polled holds the polling state of a feed
+type polled struct {
+	url      string
+	next     time.Time
+	failures int
+	running  bool
+}
+
+// Scheduler polls each registered feed on its own interval, derived from
+// the hints published by the feed, and emits the fetched items on a channel
+// The interval is the feed TTL or update period if the fetcher reports
+// them (see Hinter), the default interval otherwise
+// It is safe to use concurrently
+type Scheduler struct {
+	fetcher Fetcher
+	opts    SchedulerOptions
+	updates chan Update
+	wake    chan struct{}
+
+	mu    sync.Mutex
+	feeds map[string]*polled
+}
+
+// NewScheduler returns a Scheduler polling the feeds with fetcher
+func NewScheduler(fetcher Fetcher, opts SchedulerOptions) *Scheduler {
+	if opts.DefaultInterval <= 0 {
+		opts.DefaultInterval = DefaultPollInterval
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+
+	return &Scheduler{
+		fetcher: fetcher,
+		opts:    opts,
+		updates: make(chan Update),
+		wake:    make(chan struct{}, 1),
+		feeds:   make(map[string]*polled),
+	}
+}
+
+// Updates returns the channel where the fetched items are emitted
+// It is closed when Run returns
+func (s *Scheduler) Updates() <-chan Update {
+	return s.updates
+}
+
+// Add registers the feed at url with the specified name, to be polled right away
+// Adding a feed already registered with the same URL does nothing
+func (s *Scheduler) Add(name, url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.feeds[name]
+	if ok && p.url == url {
+		return
+	}
+	s.feeds[name] = &polled{url: url}
+
+	if ok {
+		s.forget(p.url)
+	}
+
+	s.notify()
+}
+
+// Remove stops polling the feed with the specified name
+func (s *Scheduler) Remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.feeds[name]
+	if !ok {
+		return
+	}
+	delete(s.feeds, name)
+
+	s.forget(p.url)
+}
+
+// forget drops what the fetcher remembers about the feed at url,
+// unless another feed is polled from the same url
+// s.mu must be held
+func (s *Scheduler) forget(url string) {
+	for _, p := range s.feeds {
+		if p.url == url {
+			return
+		}
+	}
+
+	if f, ok := s.fetcher.(Forgetter); ok {
+		f.Forget(url)
+	}
+}
+
+// notify wakes up the polling loop
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run polls the registered feeds until ctx is canceled
+// It closes the updates channel before returning
+func (s *Scheduler) Run(ctx context.Context) {
+	defer close(s.updates)
+
+	slots := make(chan struct{}, s.opts.Concurrency)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		wait, ok := s.dispatch(ctx, slots, &wg)
+
+		// without free slots, wait for a poll to finish
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if ok {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-s.wake:
+		case <-timeout:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// dispatch starts polling the feeds that are due, as long as there are free slots
+// It returns the time to wait for the next feed to be due, or false
+// if some feeds are due but there are no free slots
+func (s *Scheduler) dispatch(ctx context.Context, slots chan struct{}, wg *sync.WaitGroup) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	wait := s.opts.DefaultInterval
+
+	for name, p := range s.feeds {
+		if p.running {
+			continue
+		}
+
+		if d := p.next.Sub(now); d > 0 {
+			if d < wait {
+				wait = d
+			}
+			continue
+		}
+
+		select {
+		case slots <- struct{}{}:
+		default:
+			return 0, false
+		}
+
+		p.running = true
+		wg.Add(1)
+		go func(name string, p *polled) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			s.poll(ctx, name, p)
+		}(name, p)
+	}
+
+	return wait, true
+}
+
+// poll fetches the feed, emits its items and schedules the next poll
+func (s *Scheduler) poll(ctx context.Context, name string, p *polled) {
+	items, err := s.fetcher.FetchWithContext(ctx, p.url)
+
+	if err == nil && len(items) > 0 && s.registered(name, p) {
+		select {
+		case s.updates <- Update{Name: name, URL: p.url, Items: items}:
+		case <-ctx.Done():
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// a canceled poll is not a failure of the feed
+	if err != nil && ctx.Err() == nil {
+		p.failures++
+	} else if err == nil {
+		p.failures = 0
+	}
+	p.next = s.next(time.Now(), p)
+	p.running = false
+
+	s.notify()
+}
+
+// registered reports whether p is still the polling state of the feed
+func (s *Scheduler) registered(name string, p *polled) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.feeds[name] == p
+}
+
+// next returns the time of the next poll of the feed
+func (s *Scheduler) next(now time.Time, p *polled) time.Time {
+	var schedule Schedule
+	if h, ok := s.fetcher.(Hinter); ok {
+		schedule, _ = h.Schedule(p.url)
+	}
+
+	interval := schedule.Interval()
+	if interval <= 0 {
+		interval = s.opts.DefaultInterval
+	}
+	if s.opts.MinInterval > 0 && interval < s.opts.MinInterval {
+		interval = s.opts.MinInterval
+	}
+	if s.opts.MaxInterval > 0 && interval > s.opts.MaxInterval {
+		interval = s.opts.MaxInterval
+	}
+
+	// back off exponentially on failures, up to MaxBackoff
+	// or the usual interval, if longer
+	if p.failures > 0 {
+		limit := s.opts.MaxBackoff
+		if limit < interval {
+			limit = interval
+		}
+
+		for i := 0; i < p.failures && interval < limit; i++ {
+			interval *= 2
+		}
+		if interval > limit {
+			interval = limit
+		}
+	}
+
+	if s.opts.Jitter > 0 {
+		interval += time.Duration((rand.Float64()*2 - 1) * s.opts.Jitter * float64(interval))
+	}
+
+	return schedule.Next(now.Add(interval))
+}
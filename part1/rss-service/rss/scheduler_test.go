@@ -0,0 +1,202 @@
+// +build !integration
+
+package rss
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestScheduleHints(t *testing.T) {
+	feed, err := NewClient(ClientOptions{}).Parser.ParseString(`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:sy="http://purl.org/rss/1.0/modules/syndication/">
+<channel>
+	<title>test</title>
+	<ttl>30</ttl>
+	<skipHours><hour>0</hour><hour>1</hour></skipHours>
+	<skipDays><day>Sunday</day></skipDays>
+	<sy:updatePeriod>hourly</sy:updatePeriod>
+	<sy:updateFrequency>2</sy:updateFrequency>
+</channel>
+</rss>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := scheduleOf(feed)
+
+	if s.TTL != 30*time.Minute {
+		t.Fatalf("expected TTL %v, got %v\n", 30*time.Minute, s.TTL)
+	}
+	if s.UpdatePeriod != 30*time.Minute {
+		t.Fatalf("expected update period %v, got %v\n", 30*time.Minute, s.UpdatePeriod)
+	}
+	if len(s.SkipHours) != 2 || len(s.SkipDays) != 1 || s.SkipDays[0] != time.Sunday {
+		t.Fatalf("unexpected skip hours %v and days %v\n", s.SkipHours, s.SkipDays)
+	}
+
+	// Sunday 10:30 GMT: the first hour allowed is Monday 02:00
+	next := s.Next(time.Date(2020, time.December, 6, 10, 30, 0, 0, time.UTC))
+	if expected := time.Date(2020, time.December, 7, 2, 0, 0, 0, time.UTC); !next.Equal(expected) {
+		t.Fatalf("expected next poll at %v, got %v\n", expected, next)
+	}
+}
+
+type countingFetcher struct {
+	mu      sync.Mutex
+	calls   int
+	running int
+	max     int
+	delay   time.Duration
+	err     error
+}
+
+func (cf *countingFetcher) FetchWithContext(ctx context.Context, url string) ([]*gofeed.Item, error) {
+	cf.mu.Lock()
+	cf.calls++
+	cf.running++
+	if cf.running > cf.max {
+		cf.max = cf.running
+	}
+	cf.mu.Unlock()
+
+	defer func() {
+		cf.mu.Lock()
+		cf.running--
+		cf.mu.Unlock()
+	}()
+
+	select {
+	case <-time.After(cf.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if cf.err != nil {
+		return nil, cf.err
+	}
+
+	return []*gofeed.Item{{GUID: url}}, nil
+}
+
+func (cf *countingFetcher) Fetch(url string) ([]*gofeed.Item, error) {
+	return cf.FetchWithContext(context.Background(), url)
+}
+
+func (cf *countingFetcher) stats() (int, int) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	return cf.calls, cf.max
+}
+
+// runScheduler runs s for d, returning the updates emitted
+func runScheduler(s *Scheduler, d time.Duration) []Update {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	go s.Run(ctx)
+
+	var updates []Update
+	for update := range s.Updates() {
+		updates = append(updates, update)
+	}
+
+	return updates
+}
+
+func TestSchedulerPolls(t *testing.T) {
+	fetcher := &countingFetcher{}
+
+	s := NewScheduler(fetcher, SchedulerOptions{
+		DefaultInterval: 50 * time.Millisecond,
+	})
+	s.Add("test", "test-url")
+
+	updates := runScheduler(s, 275*time.Millisecond)
+
+	// polled right away, then every 50ms
+	if len(updates) < 4 || len(updates) > 6 {
+		t.Fatalf("expected 4 to 6 updates, got %d\n", len(updates))
+	}
+	if updates[0].Name != "test" || updates[0].URL != "test-url" || len(updates[0].Items) != 1 {
+		t.Fatalf("unexpected update %+v\n", updates[0])
+	}
+}
+
+func TestSchedulerBackoff(t *testing.T) {
+	fetcher := &countingFetcher{err: errors.New("feed down")}
+
+	s := NewScheduler(fetcher, SchedulerOptions{
+		DefaultInterval: 20 * time.Millisecond,
+		MaxBackoff:      time.Second,
+	})
+	s.Add("test", "test-url")
+
+	if updates := runScheduler(s, 300*time.Millisecond); len(updates) != 0 {
+		t.Fatalf("expected no updates, got %d\n", len(updates))
+	}
+
+	// polled at 0, 40, 120 and 280ms, instead of every 20ms
+	if calls, _ := fetcher.stats(); calls > 5 {
+		t.Fatalf("expected the polls to back off, got %d polls\n", calls)
+	}
+}
+
+func TestSchedulerConcurrency(t *testing.T) {
+	fetcher := &countingFetcher{delay: 50 * time.Millisecond}
+
+	s := NewScheduler(fetcher, SchedulerOptions{
+		DefaultInterval: time.Minute,
+		Concurrency:     2,
+	})
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		s.Add(name, name+"-url")
+	}
+
+	updates := runScheduler(s, 250*time.Millisecond)
+
+	if len(updates) != 5 {
+		t.Fatalf("expected 5 updates, got %d\n", len(updates))
+	}
+	if _, max := fetcher.stats(); max != 2 {
+		t.Fatalf("expected 2 concurrent polls at most, got %d\n", max)
+	}
+}
+
+// forgettingFetcher records the feeds forgotten
+type forgettingFetcher struct {
+	countingFetcher
+	forgotten []string
+}
+
+func (ff *forgettingFetcher) Forget(url string) {
+	ff.forgotten = append(ff.forgotten, url)
+}
+
+func TestSchedulerForget(t *testing.T) {
+	ff := &forgettingFetcher{}
+	s := NewScheduler(Chain(ff, WithTimeout(time.Second)), SchedulerOptions{})
+
+	s.Add("a", "first-url")
+	s.Add("b", "first-url")
+
+	// another feed is polled from the same url
+	s.Remove("a")
+	if len(ff.forgotten) != 0 {
+		t.Fatalf("expected no feeds forgotten, got %v\n", ff.forgotten)
+	}
+
+	// the url of the feed changes
+	s.Add("b", "second-url")
+	s.Remove("b")
+
+	if len(ff.forgotten) != 2 || ff.forgotten[0] != "first-url" || ff.forgotten[1] != "second-url" {
+		t.Fatalf("expected both urls forgotten in order, got %v\n", ff.forgotten)
+	}
+}
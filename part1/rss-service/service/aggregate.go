@@ -0,0 +1,601 @@
+package service
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Pippolo84/go-services-patterns/rss-service/rss"
+	"github.com/gorilla/mux"
+)
+
+// RSS 2.0: https://www.rssboard.org/rss-specification
+// Atom: https://tools.ietf.org/html/rfc4287
+// JSON Feed: https://www.jsonfeed.org/version/1.1/
+const (
+	// aggregateSize is the maximum number of items in an aggregated feed
+	aggregateSize int = 100
+	// aggregateTitle is the title of the aggregated feeds
+	aggregateTitle string = "rss-service"
+
+	// formatPattern matches the extension selecting the format of an aggregated feed,
+	// if missing the format is negotiated with the Accept header
+	formatPattern string = `(?:\.(?:rss|atom|json))?`
+
+	// aggregateTimeout is the maximum time to fetch the aggregated feeds:
+	// shorter than SrvWriteTimeout, to leave the time to render them
+	aggregateTimeout time.Duration = SrvWriteTimeout - 2*time.Second
+
+	// feedErrorHeader reports a feed missing from the aggregated one,
+	// with the reason: the response has one for each failing feed
+	feedErrorHeader string = "X-Feed-Error"
+)
+
+// aggregated is an aggregated feed, ready to be rendered
+type aggregated struct {
+	id      string
+	title   string
+	selfURL string
+	homeURL string
+	updated time.Time
+	entries []aggregatedEntry
+}
+
+// aggregatedEntry is an item of an aggregated feed
+type aggregatedEntry struct {
+	rss.Entry
+	id       string
+	feedURL  string
+	category string
+}
+
+// format renders an aggregated feed in a media type
+type format struct {
+	mediaType string
+	render    func(w io.Writer, agg aggregated) error
+}
+
+// formats are the formats of the aggregated feeds, by extension
+var formats = map[string]format{
+	".rss":  {mediaType: "application/rss+xml", render: renderRSS},
+	".atom": {mediaType: "application/atom+xml", render: renderAtom},
+	".json": {mediaType: "application/feed+json", render: renderJSONFeed},
+}
+
+// acceptedFormats maps the media types of the Accept header to the extension of their format
+// The wildcards match only the media types of the formats
+var acceptedFormats = map[string]string{
+	"application/rss+xml":   ".rss",
+	"application/atom+xml":  ".atom",
+	"application/feed+json": ".json",
+	"application/json":      ".json",
+	"application/xml":       ".rss",
+	"text/xml":              ".rss",
+}
+
+// offeredFormats are the extensions of the formats, the first one being preferred
+var offeredFormats = []string{".rss", ".atom", ".json"}
+
+// acceptance is the quality given by the Accept header to a format
+type acceptance struct {
+	q float64
+	// specificity is 2 for a media type, 1 for a type/* range and 0 for */*
+	specificity int
+	// index is the position of the media range in the header
+	index int
+}
+
+// better reports whether a is preferred to b: the higher quality wins,
+// then the more specific media range and then the first one in the header
+func (a acceptance) better(b acceptance) bool {
+	if a.q != b.q {
+		return a.q > b.q
+	}
+	if a.specificity != b.specificity {
+		return a.specificity > b.specificity
+	}
+
+	return a.index < b.index
+}
+
+// negotiate returns the extension of the format preferred by the Accept header,
+// RSS if the header is missing, false if none of the formats is acceptable
+// The quality of a format is given by its most specific media range,
+// so that q=0 refuses a format even if a wildcard accepts the others
+func negotiate(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return ".rss", true
+	}
+
+	accepted := make(map[string]acceptance)
+	for i, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		for _, ext := range offeredFormats {
+			specificity, ok := matchFormat(mediaType, ext)
+			if !ok {
+				continue
+			}
+
+			a := acceptance{q: q, specificity: specificity, index: i}
+			prev, ok := accepted[ext]
+			switch {
+			case !ok, specificity > prev.specificity:
+				accepted[ext] = a
+			case specificity == prev.specificity && q > prev.q:
+				// e.g. both application/json and application/feed+json
+				accepted[ext] = a
+			}
+		}
+	}
+
+	var best string
+	var bestAcceptance acceptance
+	for _, ext := range offeredFormats {
+		a, ok := accepted[ext]
+		if !ok || a.q <= 0 {
+			continue
+		}
+
+		// on a tie, the first offered format wins
+		if best == "" || a.better(bestAcceptance) {
+			best, bestAcceptance = ext, a
+		}
+	}
+
+	return best, best != ""
+}
+
+// matchFormat reports whether the media range matches the format with extension ext,
+// and how specific the match is
+func matchFormat(mediaRange string, ext string) (int, bool) {
+	if mediaRange == "*/*" {
+		return 0, true
+	}
+
+	if strings.HasSuffix(mediaRange, "/*") {
+		typ := strings.TrimSuffix(mediaRange, "*")
+		return 1, strings.HasPrefix(formats[ext].mediaType, typ)
+	}
+
+	return 2, acceptedFormats[mediaRange] == ext
+}
+
+// aggregate renders the latest items of all the feeds, or of the feeds of a category
+// (and of its subcategories), as a single RSS, Atom or JSON feed
+func (svc *Service) aggregate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	ext := vars["format"]
+	if ext == "" {
+		w.Header().Add("Vary", "Accept")
+
+		var ok bool
+		if ext, ok = negotiate(r.Header.Get("Accept")); !ok {
+			http.Error(w, "the aggregated feeds are available as application/rss+xml, application/atom+xml and application/feed+json", http.StatusNotAcceptable)
+			return
+		}
+	}
+	format := formats[ext]
+
+	category := strings.Trim(vars["category"], categorySeparator)
+	f := filter{}
+	if category != "" {
+		f.categories = []string{category}
+	}
+
+	var sources []rss.Source
+	feeds := make(map[string]Feed)
+	for _, feed := range svc.feedList() {
+		if category != "" && !f.matchCategory(feed.Category) {
+			continue
+		}
+
+		sources = append(sources, rss.Source{Name: feed.Name, URL: feed.URL})
+		feeds[feed.Name] = feed
+	}
+
+	if category != "" && len(sources) == 0 {
+		http.Error(w, "category not found", http.StatusNotFound)
+		return
+	}
+
+	// the feeds still missing when the time is up are left out, so that the
+	// aggregated feed is rendered before the server write timeout
+	ctx, cancel := context.WithTimeout(r.Context(), aggregateTimeout)
+	defer cancel()
+
+	// a failing feed is missing from the aggregated one, without failing it
+	timeline := svc.aggregator.Aggregate(ctx, sources)
+	for _, err := range timeline.Errors {
+		svc.log.Println(err)
+		w.Header().Add(feedErrorHeader, fmt.Sprintf("%s: %v", err.Name, err.Err))
+	}
+
+	base := scheme(r) + "://" + r.Host
+	agg := aggregated{
+		id:      aggregateID(category),
+		title:   aggregateTitle,
+		selfURL: base + r.URL.RequestURI(),
+		homeURL: base + "/",
+		updated: time.Now().UTC(),
+	}
+	if category != "" {
+		agg.title += ": " + category
+	}
+
+	// the same item may be published by more feeds
+	seen := make(map[string]bool)
+	for _, entry := range timeline.Entries {
+		if len(agg.entries) == aggregateSize {
+			break
+		}
+
+		id := itemID(entry.Feed, entry.Item)
+
+		key := entry.Item.Link
+		if key == "" {
+			key = id
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		agg.entries = append(agg.entries, aggregatedEntry{
+			Entry:    entry,
+			id:       id,
+			feedURL:  feeds[entry.Feed].URL,
+			category: feeds[entry.Feed].Category,
+		})
+	}
+
+	// the timeline is sorted newest first
+	if len(agg.entries) > 0 && !agg.entries[0].Time().IsZero() {
+		agg.updated = agg.entries[0].Time().UTC()
+	}
+
+	w.Header().Set("Content-Type", format.mediaType+"; charset=utf-8")
+
+	if err := format.render(w, agg); err != nil {
+		svc.log.Println(err)
+	}
+}
+
+// scheme returns the scheme of the request as sent by the client,
+// that may reach the service through a TLS terminating proxy
+func scheme(r *http.Request) string {
+	// the first proxy sets the scheme of the client
+	proto := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0])
+	if proto == "http" || proto == "https" {
+		return proto
+	}
+
+	if r.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+// aggregateID returns the ID of the aggregated feed of category, all the feeds if empty
+// It doesn't depend on the address the service is reached with, so it never changes
+func aggregateID(category string) string {
+	sum := sha1.Sum([]byte(aggregateTitle + "\x00" + category))
+	return uuidURN(sum[:])
+}
+
+// content returns the content of the entry, its description if missing
+func (e aggregatedEntry) content() string {
+	if e.Item.Content != "" {
+		return e.Item.Content
+	}
+
+	return e.Item.Description
+}
+
+// author returns the name of the author of the entry, if any
+func (e aggregatedEntry) author() string {
+	if e.Item.Author == nil {
+		return ""
+	}
+
+	return e.Item.Author.Name
+}
+
+// uuid returns the ID of the entry as a name-based (SHA-1) UUID URN
+func (e aggregatedEntry) uuid() string {
+	sum, err := hex.DecodeString(e.id)
+	if err != nil || len(sum) < 16 {
+		return "urn:sha1:" + e.id
+	}
+
+	return uuidURN(sum)
+}
+
+// uuidURN returns a name-based (SHA-1) UUID URN from the hash sum, at least 16 bytes long
+func uuidURN(sum []byte) string {
+	// version 5, RFC 4122 variant
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string      `xml:"title"`
+	Link          string      `xml:"link"`
+	Description   string      `xml:"description"`
+	LastBuildDate string      `xml:"lastBuildDate"`
+	Generator     string      `xml:"generator"`
+	Self          rssAtomLink `xml:"atom:link"`
+	Items         []rssItem   `xml:"item"`
+}
+
+type rssAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string    `xml:"title,omitempty"`
+	Link        string    `xml:"link,omitempty"`
+	Description string    `xml:"description,omitempty"`
+	Category    string    `xml:"category,omitempty"`
+	GUID        rssGUID   `xml:"guid"`
+	PubDate     string    `xml:"pubDate,omitempty"`
+	Source      rssSource `xml:"source"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssSource struct {
+	URL   string `xml:"url,attr"`
+	Value string `xml:",chardata"`
+}
+
+// renderRSS renders the aggregated feed as RSS 2.0
+func renderRSS(w io.Writer, agg aggregated) error {
+	doc := rssDocument{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         agg.title,
+			Link:          agg.homeURL,
+			Description:   "The latest items of the feeds aggregated by " + aggregateTitle,
+			LastBuildDate: agg.updated.Format(time.RFC1123Z),
+			Generator:     userAgent,
+			Self: rssAtomLink{
+				Href: agg.selfURL,
+				Rel:  "self",
+				Type: "application/rss+xml",
+			},
+		},
+	}
+
+	for _, e := range agg.entries {
+		it := rssItem{
+			Title:       e.Item.Title,
+			Link:        e.Item.Link,
+			Description: e.content(),
+			Category:    e.category,
+			GUID:        rssGUID{Value: e.id},
+			Source:      rssSource{URL: e.feedURL, Value: e.Feed},
+		}
+		// RSS requires either a title or a description
+		if it.Title == "" && it.Description == "" {
+			it.Title = e.Feed
+		}
+		if t := e.Time(); !t.IsZero() {
+			it.PubDate = t.Format(time.RFC1123Z)
+		}
+
+		doc.Channel.Items = append(doc.Channel.Items, it)
+	}
+
+	return encodeXML(w, doc)
+}
+
+type atomFeed struct {
+	XMLName   xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Updated   string      `xml:"updated"`
+	Links     []atomLink  `xml:"link"`
+	Author    atomPerson  `xml:"author"`
+	Generator string      `xml:"generator"`
+	Entries   []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published,omitempty"`
+	Links      []atomLink     `xml:"link"`
+	Authors    []atomPerson   `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Summary    *atomText      `xml:"summary"`
+	Content    *atomText      `xml:"content"`
+}
+
+// renderAtom renders the aggregated feed as Atom
+func renderAtom(w io.Writer, agg aggregated) error {
+	feed := atomFeed{
+		Title:   agg.title,
+		ID:      agg.id,
+		Updated: agg.updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: agg.selfURL, Rel: "self", Type: "application/atom+xml"},
+			{Href: agg.homeURL, Rel: "alternate"},
+		},
+		// the feed author stands for the entries without one
+		Author:    atomPerson{Name: aggregateTitle},
+		Generator: userAgent,
+	}
+
+	for _, e := range agg.entries {
+		entry := atomEntry{
+			Title:   e.Item.Title,
+			ID:      e.uuid(),
+			Updated: agg.updated.Format(time.RFC3339),
+		}
+
+		if e.Item.PublishedParsed != nil {
+			entry.Published = e.Item.PublishedParsed.UTC().Format(time.RFC3339)
+		}
+		switch {
+		case e.Item.UpdatedParsed != nil:
+			entry.Updated = e.Item.UpdatedParsed.UTC().Format(time.RFC3339)
+		case e.Item.PublishedParsed != nil:
+			entry.Updated = entry.Published
+		}
+
+		if e.Item.Link != "" {
+			entry.Links = append(entry.Links, atomLink{Href: e.Item.Link, Rel: "alternate"})
+		}
+		if author := e.author(); author != "" {
+			entry.Authors = append(entry.Authors, atomPerson{Name: author})
+		}
+		if e.category != "" {
+			entry.Categories = append(entry.Categories, atomCategory{Term: e.category})
+		}
+
+		if e.Item.Description != "" {
+			entry.Summary = &atomText{Type: "html", Value: e.Item.Description}
+		}
+		if e.Item.Content != "" {
+			entry.Content = &atomText{Type: "html", Value: e.Item.Content}
+		}
+		// Atom requires a summary for the entries without content or link
+		if entry.Summary == nil && entry.Content == nil && len(entry.Links) == 0 {
+			entry.Summary = &atomText{Type: "text", Value: e.Item.Title}
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	return encodeXML(w, feed)
+}
+
+// encodeXML writes v as an indented XML document
+func encodeXML(w io.Writer, v interface{}) error {
+	if _, err := fmt.Fprint(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(v)
+}
+
+type jsonFeed struct {
+	Version     string     `json:"version"`
+	Title       string     `json:"title"`
+	HomePageURL string     `json:"home_page_url"`
+	FeedURL     string     `json:"feed_url"`
+	Items       []jsonItem `json:"items"`
+}
+
+type jsonItem struct {
+	ID            string       `json:"id"`
+	URL           string       `json:"url,omitempty"`
+	Title         string       `json:"title,omitempty"`
+	ContentHTML   string       `json:"content_html"`
+	DatePublished string       `json:"date_published,omitempty"`
+	DateModified  string       `json:"date_modified,omitempty"`
+	Authors       []jsonAuthor `json:"authors,omitempty"`
+	Tags          []string     `json:"tags,omitempty"`
+}
+
+type jsonAuthor struct {
+	Name string `json:"name"`
+}
+
+// renderJSONFeed renders the aggregated feed as JSON Feed 1.1
+func renderJSONFeed(w io.Writer, agg aggregated) error {
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       agg.title,
+		HomePageURL: agg.homeURL,
+		FeedURL:     agg.selfURL,
+		Items:       []jsonItem{},
+	}
+
+	for _, e := range agg.entries {
+		it := jsonItem{
+			ID:          e.id,
+			URL:         e.Item.Link,
+			Title:       e.Item.Title,
+			ContentHTML: e.content(),
+		}
+
+		if t := e.Time(); !t.IsZero() {
+			it.DatePublished = t.UTC().Format(time.RFC3339)
+		}
+		if e.Item.PublishedParsed != nil && e.Item.UpdatedParsed != nil {
+			it.DateModified = e.Item.UpdatedParsed.UTC().Format(time.RFC3339)
+		}
+		if author := e.author(); author != "" {
+			it.Authors = []jsonAuthor{{Name: author}}
+		}
+		if e.category != "" {
+			it.Tags = []string{e.category}
+		}
+
+		feed.Items = append(feed.Items, it)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(feed)
+}
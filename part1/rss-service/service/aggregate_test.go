@@ -0,0 +1,324 @@
+// +build !integration
+
+package service
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Pippolo84/go-services-patterns/rss-service/rss"
+	"github.com/mmcdole/gofeed"
+)
+
+// mapFetcher is a Fetcher returning the items of each URL
+type mapFetcher map[string][]*gofeed.Item
+
+func (mf mapFetcher) FetchWithContext(ctx context.Context, url string) ([]*gofeed.Item, error) {
+	items, ok := mf[url]
+	if !ok {
+		return nil, &rss.StatusError{URL: url, StatusCode: http.StatusNotFound}
+	}
+
+	return items, nil
+}
+
+func (mf mapFetcher) Fetch(url string) ([]*gofeed.Item, error) {
+	return mf.FetchWithContext(context.Background(), url)
+}
+
+func newAggregateService(t *testing.T) *Service {
+	t.Helper()
+
+	at := func(day int) *time.Time {
+		t := time.Date(2020, time.December, day, 10, 0, 0, 0, time.UTC)
+		return &t
+	}
+
+	svc := NewService(":0", Options{})
+	svc.log = log.New(ioutil.Discard, "", log.LstdFlags)
+	svc.aggregator = rss.NewAggregator(mapFetcher{
+		"golang-url": {
+			{GUID: "1", Title: "Go 1.15 released", Link: "https://golang.org/1.15", PublishedParsed: at(1)},
+			{GUID: "2", Title: "Go 1.16 released", Link: "https://golang.org/1.16", PublishedParsed: at(3)},
+		},
+		"news-url": {
+			{GUID: "a", Title: "Go 1.16 released", Link: "https://golang.org/1.16", PublishedParsed: at(2)},
+			{GUID: "b", Title: "Elections", Link: "https://news.example.com/b", PublishedParsed: at(4)},
+		},
+	}, rss.AggregatorOptions{})
+
+	svc.setFeed(Feed{Name: "golang", URL: "golang-url", Category: "tech/golang"})
+	svc.setFeed(Feed{Name: "news", URL: "news-url"})
+	svc.setFeed(Feed{Name: "broken", URL: "broken-url", Category: "tech"})
+
+	return svc
+}
+
+func TestAggregate(t *testing.T) {
+	svc := newAggregateService(t)
+
+	testCases := []struct {
+		name      string
+		path      string
+		mediaType string
+		links     []string
+	}{
+		{
+			name:      "rss",
+			path:      "/aggregate.rss",
+			mediaType: "application/rss+xml",
+			links:     []string{"https://news.example.com/b", "https://golang.org/1.16", "https://golang.org/1.15"},
+		},
+		{
+			name:      "atom",
+			path:      "/aggregate.atom",
+			mediaType: "application/atom+xml",
+			links:     []string{"https://news.example.com/b", "https://golang.org/1.16", "https://golang.org/1.15"},
+		},
+		{
+			name:      "json",
+			path:      "/aggregate.json",
+			mediaType: "application/feed+json",
+			links:     []string{"https://news.example.com/b", "https://golang.org/1.16", "https://golang.org/1.15"},
+		},
+		{
+			name:      "category",
+			path:      "/categories/tech/aggregate.atom",
+			mediaType: "application/atom+xml",
+			links:     []string{"https://golang.org/1.16", "https://golang.org/1.15"},
+		},
+		{
+			name:      "subcategory",
+			path:      "/categories/tech/golang/aggregate.json",
+			mediaType: "application/feed+json",
+			links:     []string{"https://golang.org/1.16", "https://golang.org/1.15"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://localhost:8080"+tc.path, nil)
+			rr := httptest.NewRecorder()
+
+			svc.router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected status code %d, got %d\n", http.StatusOK, rr.Code)
+			}
+			if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, tc.mediaType) {
+				t.Fatalf("expected Content-Type %s, got %s\n", tc.mediaType, ct)
+			}
+
+			feed, err := gofeed.NewParser().ParseString(rr.Body.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(feed.Items) != len(tc.links) {
+				t.Fatalf("expected %d items, got %d\n", len(tc.links), len(feed.Items))
+			}
+			for i, link := range tc.links {
+				it := feed.Items[i]
+				if it.Link != link {
+					t.Fatalf("expected item %d to link %s, got %s\n", i, link, it.Link)
+				}
+				if it.GUID == "" || it.PublishedParsed == nil {
+					t.Fatalf("expected item %d to have a GUID and a date, got %q and %v\n", i, it.GUID, it.PublishedParsed)
+				}
+			}
+
+			if feed.Items[0].PublishedParsed.Before(*feed.Items[len(feed.Items)-1].PublishedParsed) {
+				t.Fatal("expected the items to be sorted newest first")
+			}
+		})
+	}
+}
+
+func TestAggregateStableGUIDs(t *testing.T) {
+	svc := newAggregateService(t)
+
+	guids := func() []string {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/aggregate.rss", nil)
+		rr := httptest.NewRecorder()
+
+		svc.router.ServeHTTP(rr, req)
+
+		feed, err := gofeed.NewParser().ParseString(rr.Body.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var guids []string
+		for _, it := range feed.Items {
+			guids = append(guids, it.GUID)
+		}
+		return guids
+	}
+
+	first, second := guids(), guids()
+	if strings.Join(first, ",") != strings.Join(second, ",") {
+		t.Fatalf("expected the same GUIDs, got %v and %v\n", first, second)
+	}
+}
+
+func TestAggregateFeedErrors(t *testing.T) {
+	svc := newAggregateService(t)
+
+	testCases := []struct {
+		name     string
+		path     string
+		expected []string
+	}{
+		{
+			name:     "all feeds",
+			path:     "/aggregate.rss",
+			expected: []string{"broken: "},
+		},
+		{
+			name: "no failing feeds",
+			path: "/categories/tech/golang/aggregate.rss",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://localhost:8080"+tc.path, nil)
+			rr := httptest.NewRecorder()
+
+			svc.router.ServeHTTP(rr, req)
+
+			// the other feeds are aggregated anyway
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected status code %d, got %d\n", http.StatusOK, rr.Code)
+			}
+
+			errs := rr.Header().Values(feedErrorHeader)
+			if len(errs) != len(tc.expected) {
+				t.Fatalf("expected %d feed errors, got %v\n", len(tc.expected), errs)
+			}
+			for i, prefix := range tc.expected {
+				if !strings.HasPrefix(errs[i], prefix) {
+					t.Fatalf("expected a feed error starting with %q, got %q\n", prefix, errs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAggregateAtomID(t *testing.T) {
+	svc := newAggregateService(t)
+
+	feed := func(url string, header http.Header) atomFeed {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+
+		svc.router.ServeHTTP(rr, req)
+
+		var feed atomFeed
+		if err := xml.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+			t.Fatal(err)
+		}
+		return feed
+	}
+
+	first := feed("http://localhost:8080/aggregate.atom", nil)
+	if !strings.HasPrefix(first.ID, "urn:uuid:") {
+		t.Fatalf("expected a UUID URN, got %s\n", first.ID)
+	}
+
+	// the ID doesn't depend on the address and the query
+	if second := feed("http://example.com/aggregate.atom?ref=home", nil); second.ID != first.ID {
+		t.Fatalf("expected the same ID, got %s and %s\n", first.ID, second.ID)
+	}
+
+	// but the feed of a category has its own
+	if tech := feed("http://localhost:8080/categories/tech/aggregate.atom", nil); tech.ID == first.ID {
+		t.Fatalf("expected the category to have a different ID, got %s\n", tech.ID)
+	}
+
+	// the links have the scheme of the client, even behind a TLS terminating proxy
+	proxied := feed("http://example.com/aggregate.atom", http.Header{"X-Forwarded-Proto": {"https"}})
+	if proxied.Links[0].Href != "https://example.com/aggregate.atom" {
+		t.Fatalf("expected an https self link, got %s\n", proxied.Links[0].Href)
+	}
+	if first.Links[0].Href != "http://localhost:8080/aggregate.atom" {
+		t.Fatalf("expected an http self link, got %s\n", first.Links[0].Href)
+	}
+}
+
+func TestAggregateNegotiation(t *testing.T) {
+	svc := newAggregateService(t)
+
+	testCases := []struct {
+		name      string
+		path      string
+		accept    string
+		code      int
+		mediaType string
+	}{
+		{name: "no Accept", path: "/aggregate", code: http.StatusOK, mediaType: "application/rss+xml"},
+		{name: "atom", path: "/aggregate", accept: "application/atom+xml", code: http.StatusOK, mediaType: "application/atom+xml"},
+		{name: "quality", path: "/aggregate", accept: "application/rss+xml;q=0.5, application/feed+json", code: http.StatusOK, mediaType: "application/feed+json"},
+		{name: "any", path: "/aggregate", accept: "text/html, */*;q=0.1", code: http.StatusOK, mediaType: "application/rss+xml"},
+		{name: "not acceptable", path: "/aggregate", accept: "text/html", code: http.StatusNotAcceptable},
+		{name: "extension wins", path: "/aggregate.json", accept: "application/atom+xml", code: http.StatusOK, mediaType: "application/feed+json"},
+		{name: "category", path: "/categories/tech/aggregate", accept: "application/atom+xml", code: http.StatusOK, mediaType: "application/atom+xml"},
+		{name: "unknown category", path: "/categories/sport/aggregate.rss", code: http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://localhost:8080"+tc.path, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			rr := httptest.NewRecorder()
+
+			svc.router.ServeHTTP(rr, req)
+
+			if rr.Code != tc.code {
+				t.Fatalf("expected status code %d, got %d\n", tc.code, rr.Code)
+			}
+			if ct := rr.Header().Get("Content-Type"); tc.mediaType != "" && !strings.HasPrefix(ct, tc.mediaType) {
+				t.Fatalf("expected Content-Type %s, got %s\n", tc.mediaType, ct)
+			}
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		accept string
+		ext    string
+		ok     bool
+	}{
+		{name: "no Accept", accept: "", ext: ".rss", ok: true},
+		{name: "media type", accept: "application/atom+xml", ext: ".atom", ok: true},
+		{name: "alias", accept: "application/json", ext: ".json", ok: true},
+		{name: "quality", accept: "application/rss+xml;q=0.5, application/feed+json", ext: ".json", ok: true},
+		{name: "tie", accept: "application/atom+xml, application/rss+xml", ext: ".atom", ok: true},
+		{name: "any", accept: "text/html, */*;q=0.1", ext: ".rss", ok: true},
+		{name: "refused", accept: "application/rss+xml;q=0, */*", ext: ".atom", ok: true},
+		{name: "all refused", accept: "application/rss+xml;q=0, application/atom+xml;q=0, application/feed+json;q=0, */*", ok: false},
+		{name: "refused by wildcard", accept: "*/*;q=0", ok: false},
+		{name: "specific over wildcard", accept: "*/*, application/atom+xml", ext: ".atom", ok: true},
+		{name: "specific over type wildcard", accept: "application/*, application/feed+json", ext: ".json", ok: true},
+		{name: "not acceptable", accept: "text/html", ok: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ext, ok := negotiate(tc.accept)
+			if ext != tc.ext || ok != tc.ok {
+				t.Fatalf("expected %q and %t, got %q and %t\n", tc.ext, tc.ok, ext, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Pippolo84/go-services-patterns/part1/timeouts/server/sse"
+)
+
+// item is a polled item, with the event streaming it
+type item struct {
+	event    sse.Event
+	news     News
+	category string
+	// timestamp is the publication time of the item,
+	// or the time it was polled if the feed doesn't tell
+	timestamp time.Time
+}
+
+// filter selects the items of a /items stream
+// The zero value selects all the items
+type filter struct {
+	// feeds are the names of the feeds to stream, all of them if empty
+	feeds map[string]bool
+	// categories are the categories to stream, with their subcategories
+	categories []string
+	// keywords must all be in the title or in the description, regardless of case
+	keywords []string
+	// since skips the items published before it, if not zero
+	since time.Time
+}
+
+// parseFilter returns the filter in the query of the request:
+//
+//	feed:     the name of a feed, repeated to stream more feeds
+//	category: a category, with its subcategories, repeated to stream more categories
+//	q:        a keyword in the title or in the description, repeated to require more keywords
+//	since:    a RFC 3339 timestamp, the items published before it are skipped
+func parseFilter(r *http.Request) (filter, error) {
+	query := r.URL.Query()
+
+	var f filter
+
+	for _, name := range query["feed"] {
+		if f.feeds == nil {
+			f.feeds = make(map[string]bool)
+		}
+		f.feeds[name] = true
+	}
+
+	for _, category := range query["category"] {
+		f.categories = append(f.categories, strings.Trim(category, categorySeparator))
+	}
+
+	for _, keyword := range query["q"] {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			f.keywords = append(f.keywords, strings.ToLower(keyword))
+		}
+	}
+
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return filter{}, fmt.Errorf("since must be a RFC 3339 timestamp: %w", err)
+		}
+		f.since = t
+	}
+
+	return f, nil
+}
+
+// match reports whether the item is selected by the filter
+func (f filter) match(it item) bool {
+	if f.feeds != nil && !f.feeds[it.news.Feed] {
+		return false
+	}
+
+	if len(f.categories) > 0 && !f.matchCategory(it.category) {
+		return false
+	}
+
+	if len(f.keywords) > 0 {
+		text := strings.ToLower(it.news.Title + "\n" + it.news.Description)
+		for _, keyword := range f.keywords {
+			if !strings.Contains(text, keyword) {
+				return false
+			}
+		}
+	}
+
+	if !f.since.IsZero() && it.timestamp.Before(f.since) {
+		return false
+	}
+
+	return true
+}
+
+// matchCategory reports whether category is one of the filter categories or a subcategory
+func (f filter) matchCategory(category string) bool {
+	for _, c := range f.categories {
+		if category == c || strings.HasPrefix(category, c+categorySeparator) {
+			return true
+		}
+	}
+
+	return false
+}
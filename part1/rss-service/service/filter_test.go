@@ -0,0 +1,153 @@
+// +build !integration
+
+package service
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Pippolo84/go-services-patterns/part1/timeouts/server/sse"
+	"github.com/Pippolo84/go-services-patterns/rss-service/rss"
+	"github.com/mmcdole/gofeed"
+)
+
+func TestFilter(t *testing.T) {
+	published := time.Date(2020, time.December, 7, 10, 0, 0, 0, time.UTC)
+
+	it := item{
+		news: News{
+			Feed:        "test",
+			Title:       "Go 1.16 released",
+			Description: "Embedding files in the binary",
+		},
+		category:  "tech/golang",
+		timestamp: published,
+	}
+
+	testCases := []struct {
+		name  string
+		query string
+		match bool
+	}{
+		{name: "no filter", query: "", match: true},
+		{name: "feed", query: "feed=other&feed=test", match: true},
+		{name: "other feed", query: "feed=other", match: false},
+		{name: "category", query: "category=tech/golang", match: true},
+		{name: "parent category", query: "category=tech", match: true},
+		{name: "category prefix", query: "category=te", match: false},
+		{name: "keyword in title", query: "q=RELEASED", match: true},
+		{name: "keyword in description", query: "q=embedding&q=go", match: true},
+		{name: "missing keyword", query: "q=embedding&q=generics", match: false},
+		{name: "since before", query: "since=2020-12-07T09:00:00Z", match: true},
+		{name: "since same instant", query: "since=2020-12-07T11:00:00%2B01:00", match: true},
+		{name: "since later", query: "since=2020-12-08T00:00:00Z", match: false},
+		{name: "all", query: "feed=test&category=tech&q=go&since=2020-12-01T00:00:00Z", match: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/items?"+tc.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := parseFilter(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if match := f.match(it); match != tc.match {
+				t.Fatalf("expected match to be %v, got %v\n", tc.match, match)
+			}
+		})
+	}
+}
+
+func TestItemsHandlerFilter(t *testing.T) {
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	svc := Service{
+		log: log.New(f, "", log.LstdFlags),
+		feeds: map[string]string{
+			"golang": "golang-url",
+			"news":   "news-url",
+		},
+		categories: map[string]string{
+			"golang": "tech",
+		},
+		hub: newHub(10),
+	}
+
+	svc.publish(rss.Update{
+		Name: "golang",
+		URL:  "golang-url",
+		Items: []*gofeed.Item{
+			{GUID: "1", Title: "Go 1.15 released"},
+			{GUID: "2", Title: "Go 1.16 released"},
+			{GUID: "3", Title: "Go 1.16 survey"},
+		},
+	})
+	svc.publish(rss.Update{
+		Name:  "news",
+		URL:   "news-url",
+		Items: []*gofeed.Item{{GUID: "4", Title: "Go 1.16 on the news"}},
+	})
+
+	stream := func(query, lastID string) string {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/items?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lastID != "" {
+			req.Header.Set(sse.LastEventIDHeader, lastID)
+		}
+		rr := httptest.NewRecorder()
+
+		svc.streamItems(rr, req)
+
+		return rr.Body.String()
+	}
+
+	body := stream("category=tech&q=1.16", "")
+	if strings.Count(body, "id: ") != 2 || strings.Contains(body, "on the news") {
+		t.Fatalf("expected the 2 matching items, got %q\n", body)
+	}
+
+	// a filtered stream resumes after the last item it received
+	body = stream("category=tech&q=1.16", itemID("golang", &gofeed.Item{GUID: "2"}))
+	if strings.Count(body, "id: ") != 1 || !strings.Contains(body, "survey") {
+		t.Fatalf("expected only the missed matching item, got %q\n", body)
+	}
+}
+
+func TestItemsHandlerBadFilter(t *testing.T) {
+	svc := Service{
+		log:   log.New(os.Stdout, "", log.LstdFlags),
+		feeds: map[string]string{},
+		hub:   newHub(10),
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/items?since=yesterday", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+
+	svc.streamItems(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code %d, got %d\n", http.StatusBadRequest, rr.Code)
+	}
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"sync"
+)
+
+// subscriberBuffer is the number of items queued for a /items stream
+// before dropping it as too slow
+const subscriberBuffer int = 64
+
+// hub broadcasts the polled items to the /items streams
+// It keeps the last items, to replay them to new and resuming streams
+// It is safe to use concurrently
+type hub struct {
+	mu     sync.Mutex
+	size   int
+	recent []item
+	subs   map[chan item]struct{}
+	closed bool
+}
+
+// newHub returns a hub keeping the last size items
+func newHub(size int) *hub {
+	return &hub{
+		size:   size,
+		recent: make([]item, 0, size),
+		subs:   make(map[chan item]struct{}),
+	}
+}
+
+// subscribe returns the recent items following the one with ID lastID,
+// all of them if lastID is empty or unknown, and a channel receiving the next items
+// The channel is closed when the hub is closed or when the subscriber falls too much behind:
+// it can then resume from the last item received
+// The returned function unsubscribes and must be called when done
+func (h *hub) subscribe(lastID string) ([]item, <-chan item, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	backlog := h.since(lastID)
+
+	items := make(chan item, subscriberBuffer)
+	if h.closed {
+		close(items)
+		return backlog, items, func() {}
+	}
+	h.subs[items] = struct{}{}
+
+	return backlog, items, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if _, ok := h.subs[items]; ok {
+			delete(h.subs, items)
+			close(items)
+		}
+	}
+}
+
+// since returns the recent items following the one with ID lastID
+func (h *hub) since(lastID string) []item {
+	i := len(h.recent) - 1
+	for ; i >= 0; i-- {
+		if h.recent[i].event.ID == lastID {
+			break
+		}
+	}
+
+	backlog := make([]item, len(h.recent)-(i+1))
+	copy(backlog, h.recent[i+1:])
+
+	return backlog
+}
+
+// publish stores the item among the recent ones and sends it to all the subscribers
+func (h *hub) publish(it item) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+
+	if h.size > 0 {
+		if len(h.recent) == h.size {
+			h.recent = append(h.recent[:0], h.recent[1:]...)
+		}
+		h.recent = append(h.recent, it)
+	}
+
+	for items := range h.subs {
+		select {
+		case items <- it:
+		default:
+			// too slow, never block the other subscribers
+			delete(h.subs, items)
+			close(items)
+		}
+	}
+}
+
+// close closes the channels of all the subscribers
+func (h *hub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for items := range h.subs {
+		delete(h.subs, items)
+		close(items)
+	}
+}
@@ -24,7 +24,7 @@ import (
 func startServer(t *testing.T, addr string) (*Service, <-chan error) {
 	t.Helper()
 
-	svc := NewService(addr)
+	svc := NewService(addr, Options{DataDir: t.TempDir()})
 
 	errs := make(chan error)
 
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+// OPML: http://opml.org/spec2.opml
+// The folders of the outlines are mapped to the feed categories,
+// nested folders are joined with categorySeparator
+const categorySeparator = "/"
+
+// opmlMediaTypes are the media types accepted for an OPML document
+var opmlMediaTypes = map[string]bool{
+	"text/x-opml":     true,
+	"text/xml":        true,
+	"application/xml": true,
+}
+
+type opml struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    opmlHead `xml:"head"`
+	Body    opmlBody `xml:"body"`
+}
+
+type opmlHead struct {
+	Title       string `xml:"title,omitempty"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type opmlBody struct {
+	Outlines []outline `xml:"outline"`
+}
+
+type outline struct {
+	Text     string    `xml:"text,attr"`
+	Title    string    `xml:"title,attr,omitempty"`
+	Type     string    `xml:"type,attr,omitempty"`
+	XMLURL   string    `xml:"xmlUrl,attr,omitempty"`
+	Outlines []outline `xml:"outline"`
+}
+
+// OPMLError is the error importing a single outline of an OPML document
+type OPMLError struct {
+	Name  string `json:"name"`
+	URL   string `json:"url,omitempty"`
+	Error string `json:"error"`
+}
+
+// OPMLImport is the result of an OPML import
+type OPMLImport struct {
+	Imported int         `json:"imported"`
+	Errors   []OPMLError `json:"errors"`
+}
+
+// feeds returns the feeds in the outline and in the nested ones,
+// with the errors of the outlines that are not valid feeds
+func (o outline) feeds(category string) ([]Feed, []OPMLError) {
+	name := o.Title
+	if name == "" {
+		name = o.Text
+	}
+
+	// an outline without xmlUrl is a folder
+	if o.XMLURL == "" {
+		if len(o.Outlines) == 0 {
+			return nil, []OPMLError{{Name: name, Error: "missing xmlUrl"}}
+		}
+
+		if name != "" {
+			if category != "" {
+				category += categorySeparator
+			}
+			category += name
+		}
+
+		var feeds []Feed
+		var errs []OPMLError
+		for _, child := range o.Outlines {
+			f, e := child.feeds(category)
+			feeds = append(feeds, f...)
+			errs = append(errs, e...)
+		}
+
+		return feeds, errs
+	}
+
+	if name == "" {
+		name = o.XMLURL
+	}
+
+	if err := validateFeedURL(o.XMLURL); err != nil {
+		return nil, []OPMLError{{Name: name, URL: o.XMLURL, Error: err.Error()}}
+	}
+
+	return []Feed{{Name: name, URL: o.XMLURL, Category: category}}, nil
+}
+
+func (svc *Service) importOPML(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !opmlMediaTypes[mediaType] {
+		http.Error(w, "Content-Type must be text/x-opml, text/xml or application/xml", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+	dec := xml.NewDecoder(r.Body)
+	// exports of other readers may not be UTF-8
+	dec.CharsetReader = charset.NewReaderLabel
+
+	var doc opml
+	if err := dec.Decode(&doc); err != nil {
+		// MaxBytesReader doesn't export its error
+		if err.Error() == "http: request body too large" {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		http.Error(w, "badly formed OPML document", http.StatusBadRequest)
+		return
+	}
+
+	result := OPMLImport{Errors: []OPMLError{}}
+	seen := make(map[string]bool)
+
+	for _, o := range doc.Body.Outlines {
+		feeds, errs := o.feeds("")
+		result.Errors = append(result.Errors, errs...)
+
+		for _, feed := range feeds {
+			if seen[feed.Name] {
+				result.Errors = append(result.Errors, OPMLError{
+					Name:  feed.Name,
+					URL:   feed.URL,
+					Error: "duplicated name",
+				})
+				continue
+			}
+			seen[feed.Name] = true
+
+			// the feeds already there are never overwritten:
+			// an identical one is left as it is, a different one is a conflict
+			if existing, ok := svc.addNewFeed(feed); ok {
+				if existing != feed {
+					result.Errors = append(result.Errors, OPMLError{
+						Name:  feed.Name,
+						URL:   feed.URL,
+						Error: "a feed with the same name already exists",
+					})
+				}
+				continue
+			}
+			result.Imported++
+		}
+	}
+
+	if result.Imported > 0 && !svc.persistFeeds(w) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		svc.log.Println(err)
+	}
+}
+
+func (svc *Service) exportOPML(w http.ResponseWriter, r *http.Request) {
+	doc := opml{
+		Version: "2.0",
+		Head: opmlHead{
+			Title:       "rss-service feeds",
+			DateCreated: time.Now().UTC().Format(time.RFC1123Z),
+		},
+	}
+
+	// root holds the outlines of the feeds, in a folder for each category
+	root := &outline{}
+	for _, feed := range svc.feedList() {
+		parent := root
+		if feed.Category != "" {
+			for _, name := range strings.Split(feed.Category, categorySeparator) {
+				parent = parent.folder(name)
+			}
+		}
+
+		parent.Outlines = append(parent.Outlines, outline{
+			Text:   feed.Name,
+			Title:  feed.Name,
+			Type:   "rss",
+			XMLURL: feed.URL,
+		})
+	}
+	doc.Body.Outlines = root.Outlines
+
+	w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+
+	if _, err := fmt.Fprint(w, xml.Header); err != nil {
+		svc.log.Println(err)
+		return
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		svc.log.Println(err)
+	}
+}
+
+// folder returns the folder outline with the specified name in o, adding it if missing
+func (o *outline) folder(name string) *outline {
+	for i := range o.Outlines {
+		if o.Outlines[i].XMLURL == "" && o.Outlines[i].Text == name {
+			return &o.Outlines[i]
+		}
+	}
+
+	o.Outlines = append(o.Outlines, outline{Text: name, Title: name})
+	return &o.Outlines[len(o.Outlines)-1]
+}
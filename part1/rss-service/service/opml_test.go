@@ -0,0 +1,198 @@
+// +build !integration
+
+package service
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Pippolo84/go-services-patterns/rss-service/rss"
+)
+
+func TestImportOPML(t *testing.T) {
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	svc := Service{
+		log: log.New(f, "", log.LstdFlags),
+		feeds: map[string]string{
+			"existing":  "http://example.com/existing.xml",
+			"unchanged": "http://example.com/unchanged.xml",
+		},
+		scheduler:  rss.NewScheduler(nil, rss.SchedulerOptions{}),
+		aggregator: rss.NewAggregator(nil, rss.AggregatorOptions{}),
+	}
+
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="2.0">
+  <head><title>subscriptions</title></head>
+  <body>
+    <outline text="top" type="rss" xmlUrl="http://example.com/top.xml"/>
+    <outline text="existing" type="rss" xmlUrl="http://example.com/other.xml"/>
+    <outline text="unchanged" type="rss" xmlUrl="http://example.com/unchanged.xml"/>
+    <outline text="Podcasts">
+      <outline text="Comedy">
+        <outline text="first" type="rss" xmlUrl="https://example.com/first.xml"/>
+      </outline>
+      <outline text="second" title="Second" type="rss" xmlUrl="https://example.com/second.xml"/>
+      <outline text="broken" type="rss" xmlUrl="ftp://example.com/broken.xml"/>
+      <outline text="empty"/>
+    </outline>
+    <outline text="top" type="rss" xmlUrl="http://example.com/duplicated.xml"/>
+  </body>
+</opml>`
+
+	req, err := http.NewRequest(http.MethodPost, "/feeds/opml", strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "text/x-opml")
+	rr := httptest.NewRecorder()
+
+	svc.importOPML(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d\n", http.StatusOK, rr.Code)
+	}
+
+	var result OPMLImport
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Imported != 3 {
+		t.Fatalf("expected 3 feeds imported, got %d\n", result.Imported)
+	}
+	if len(result.Errors) != 4 {
+		t.Fatalf("expected 4 errors, got %+v\n", result.Errors)
+	}
+	for i, name := range []string{"existing", "broken", "empty", "top"} {
+		if result.Errors[i].Name != name {
+			t.Fatalf("expected an error for %q, got %+v\n", name, result.Errors[i])
+		}
+	}
+
+	expected := map[string]Feed{
+		"top":    {Name: "top", URL: "http://example.com/top.xml"},
+		"first":  {Name: "first", URL: "https://example.com/first.xml", Category: "Podcasts/Comedy"},
+		"Second": {Name: "Second", URL: "https://example.com/second.xml", Category: "Podcasts"},
+		// the existing feeds are not overwritten
+		"existing":  {Name: "existing", URL: "http://example.com/existing.xml"},
+		"unchanged": {Name: "unchanged", URL: "http://example.com/unchanged.xml"},
+	}
+	for _, feed := range svc.feedList() {
+		if feed != expected[feed.Name] {
+			t.Fatalf("expected feed %+v, got %+v\n", expected[feed.Name], feed)
+		}
+	}
+}
+
+func TestImportOPMLMalformed(t *testing.T) {
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	svc := Service{
+		log:   log.New(f, "", log.LstdFlags),
+		feeds: map[string]string{},
+	}
+
+	testCases := []struct {
+		name        string
+		contentType string
+		body        string
+		code        int
+	}{
+		{
+			name:        "wrong content type",
+			contentType: "application/json",
+			body:        `{}`,
+			code:        http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "malformed document",
+			contentType: "application/xml",
+			body:        `<opml><body><outline`,
+			code:        http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "/feeds/opml", strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", tc.contentType)
+			rr := httptest.NewRecorder()
+
+			svc.importOPML(rr, req)
+
+			if rr.Code != tc.code {
+				t.Fatalf("expected status code %d, got %d\n", tc.code, rr.Code)
+			}
+		})
+	}
+}
+
+func TestExportOPML(t *testing.T) {
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	svc := Service{
+		log: log.New(f, "", log.LstdFlags),
+		feeds: map[string]string{
+			"a": "http://example.com/a.xml",
+			"b": "http://example.com/b.xml",
+			"c": "http://example.com/c.xml",
+		},
+		categories: map[string]string{
+			"a": "news/tech",
+			"b": "news",
+		},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/feeds/opml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+
+	svc.exportOPML(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d\n", http.StatusOK, rr.Code)
+	}
+
+	var doc opml
+	if err := xml.NewDecoder(rr.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+
+	// news [tech [a], b], c
+	outlines := doc.Body.Outlines
+	if len(outlines) != 2 || outlines[0].Text != "news" || outlines[1].XMLURL != "http://example.com/c.xml" {
+		t.Fatalf("unexpected outlines %+v\n", outlines)
+	}
+
+	news := outlines[0].Outlines
+	if len(news) != 2 || news[0].Text != "tech" || news[1].XMLURL != "http://example.com/b.xml" {
+		t.Fatalf("unexpected news outlines %+v\n", news)
+	}
+	if tech := news[0].Outlines; len(tech) != 1 || tech[0].XMLURL != "http://example.com/a.xml" {
+		t.Fatalf("unexpected tech outlines %+v\n", tech)
+	}
+}
@@ -2,40 +2,228 @@ package service
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/Pippolo84/go-services-patterns/part1/timeouts/server/sse"
+	"github.com/Pippolo84/go-services-patterns/rss-service/rss"
 	"github.com/gorilla/mux"
+	"github.com/mmcdole/gofeed"
 )
 
+const (
+	// SrvReadTimeout is the read timeout of the HTTP server
+	SrvReadTimeout time.Duration = 5 * time.Second
+	// SrvWriteTimeout is the write timeout of the HTTP server
+	// The /items stream extends it each time it sends something
+	SrvWriteTimeout time.Duration = 10 * time.Second
+	// SrvIdleTimeout is the idle timeout of the HTTP server
+	SrvIdleTimeout time.Duration = 60 * time.Second
+
+	// maxBodySize is the maximum size of a request body
+	maxBodySize int64 = 1024 * 1024
+
+	// pollInterval is the polling interval of the feeds without hints
+	pollInterval time.Duration = 5 * time.Minute
+	// minPollInterval is the minimum polling interval, whatever the feed hints
+	minPollInterval time.Duration = time.Minute
+	// pollJitter is the fraction of the polling interval randomly added or removed
+	pollJitter float64 = 0.1
+	// pollConcurrency is the maximum number of feeds fetched at the same time
+	pollConcurrency int = 4
+	// fetchTimeout is the maximum time to fetch a feed
+	fetchTimeout time.Duration = 10 * time.Second
+	// fetchRetries is the number of retries of a fetch failed for a temporary error
+	fetchRetries int = 2
+	// fetchBackOff is the backoff quantum between the retries
+	fetchBackOff time.Duration = 500 * time.Millisecond
+	// hostInterval is the minimum interval between two fetches from the same host
+	hostInterval time.Duration = time.Second
+	// breakerThreshold is the number of consecutive failures of a host that opens its breaker
+	breakerThreshold int = 3
+	// breakerCooldown is the time the breaker of a failing host stays open
+	breakerCooldown time.Duration = 5 * time.Minute
+
+	// userAgent is the User-Agent sent fetching the feeds
+	userAgent string = "rss-service/1.0"
+
+	// heartbeatInterval is the interval of the heartbeats in the items stream
+	heartbeatInterval time.Duration = 5 * time.Second
+	// replaySize is the number of items kept to resume a stream
+	replaySize int = 1024
+
+	// seenFile is the name of the file, in the data directory, of the items already streamed
+	seenFile string = "seen.json"
+	// feedsFile is the name of the file, in the data directory, of the feeds
+	feedsFile string = "feeds.json"
+)
+
+// Options holds the configuration of a Service
+type Options struct {
+	// DataDir is the directory where the service keeps its state
+	// If empty, the service keeps it only in memory
+	DataDir string
+	// Store is the store of the feeds
+	// If nil, the feeds are kept in a file in DataDir, or in memory
+	Store Store
+}
+
 // Service is the type of a rss service
 type Service struct {
 	server *http.Server
 	router *mux.Router
+	log    *log.Logger
+
+	mu    sync.RWMutex
+	feeds map[string]string
+	// categories holds the category of the feeds having one
+	categories map[string]string
+
+	store Store
+	// saveMu serializes the saves, so that an older list of feeds
+	// never overwrites a newer one
+	saveMu sync.Mutex
+	// loaded is set when the feeds are loaded from the store,
+	// to never overwrite them with the feeds of a service not started
+	loaded bool
+
+	seen        *rss.SeenSet
+	dedup       *rss.Dedup
+	scheduler   *rss.Scheduler
+	hub         *hub
+	aggregator  *rss.Aggregator
+	stopPolling context.CancelFunc
+	polling     chan struct{}
 }
 
 // NewService builds a new Service ready to be run
-func NewService(addr string) *Service {
+func NewService(addr string, opts Options) *Service {
+	var seenPath string
+	if opts.DataDir != "" {
+		seenPath = filepath.Join(opts.DataDir, seenFile)
+	}
+	seen := rss.NewSeenSet(seenPath, 0)
+	// stream each item only once
+	dedup := rss.NewDedup(newFetcher(false), seen)
+
+	store := opts.Store
+	if store == nil {
+		store = NewMemoryStore()
+		if opts.DataDir != "" {
+			store = NewFileStore(filepath.Join(opts.DataDir, feedsFile))
+		}
+	}
+
 	svc := &Service{
 		server: &http.Server{
 			Addr: addr,
+
+			ReadTimeout:  SrvReadTimeout,
+			WriteTimeout: SrvWriteTimeout,
+			IdleTimeout:  SrvIdleTimeout,
 		},
-		router: mux.NewRouter(),
+		router:     mux.NewRouter(),
+		log:        log.New(os.Stdout, "rss-service: ", log.LstdFlags),
+		feeds:      make(map[string]string),
+		categories: make(map[string]string),
+		store:      store,
+		seen:       seen,
+		dedup:      dedup,
+		scheduler: rss.NewScheduler(dedup, rss.SchedulerOptions{
+			DefaultInterval: pollInterval,
+			MinInterval:     minPollInterval,
+			Jitter:          pollJitter,
+			Concurrency:     pollConcurrency,
+		}),
+		hub: newHub(replaySize),
+		// the aggregated feeds list the items even if seen before
+		aggregator: rss.NewAggregator(newFetcher(true), rss.AggregatorOptions{
+			Concurrency: pollConcurrency,
+			FeedTimeout: fetchTimeout,
+		}),
 	}
 	svc.server.Handler = svc.router
+	svc.server.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		return context.WithValue(ctx, DeadlineControllerKey, NewDeadlineController(c, svc.server))
+	}
 	svc.router.HandleFunc("/feeds", svc.getFeeds).Schemes("http").Methods(http.MethodGet)
+	svc.router.HandleFunc("/feeds/opml", svc.exportOPML).Schemes("http").Methods(http.MethodGet)
+	svc.router.HandleFunc("/feeds/opml", svc.importOPML).Schemes("http").Methods(http.MethodPost)
 	svc.router.HandleFunc("/feed", svc.addFeed).Schemes("http").Methods(http.MethodPost)
+	svc.router.HandleFunc("/feed/{name}", svc.putFeed).Schemes("http").Methods(http.MethodPut)
+	svc.router.HandleFunc("/feed/{name}", svc.deleteFeed).Schemes("http").Methods(http.MethodDelete)
 	svc.router.HandleFunc("/items", svc.streamItems).Schemes("http").Methods(http.MethodGet)
+	svc.router.HandleFunc("/aggregate{format:"+formatPattern+"}", svc.aggregate).Schemes("http").Methods(http.MethodGet)
+	svc.router.HandleFunc("/categories/{category:.+}/aggregate{format:"+formatPattern+"}", svc.aggregate).Schemes("http").Methods(http.MethodGet)
 
 	return svc
 }
 
+// newFetcher returns the fetcher of the feeds: a broken or slow host
+// is isolated by its breaker, without stalling the polls of the others
+// If keepItems is set, the fetches of an unchanged feed return the items of the last fetch
+func newFetcher(keepItems bool) rss.Fetcher {
+	// the proxy, if any, is taken from the environment (HTTPS_PROXY, HTTP_PROXY and NO_PROXY)
+	client := rss.NewClient(rss.ClientOptions{
+		UserAgent: userAgent,
+		KeepItems: keepItems,
+	})
+
+	return rss.Chain(client,
+		rss.WithBreaker(breakerThreshold, breakerCooldown),
+		rss.WithRetry(rss.Exponential{Quantum: fetchBackOff}, fetchRetries),
+		rss.WithRateLimit(hostInterval),
+		rss.WithTimeout(fetchTimeout),
+	)
+}
+
 // Run starts the service
 // It returns a channel where all the errors are forwarded
+// wg is marked as done when the service is listening
 func (svc *Service) Run(wg *sync.WaitGroup) <-chan error {
 	errs := make(chan error)
 
+	loadErr := svc.loadFeeds()
+	if loadErr == nil {
+		svc.startPolling()
+	}
+
+	go func() {
+		defer close(errs)
+
+		if loadErr != nil {
+			wg.Done()
+			errs <- loadErr
+			return
+		}
+
+		ln, err := net.Listen("tcp", svc.server.Addr)
+		wg.Done()
+		if err != nil {
+			errs <- err
+			return
+		}
+
+		svc.log.Printf("start listening on %s\n", svc.server.Addr)
+
+		if err := svc.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errs <- err
+		}
+	}()
+
 	return errs
 }
 
@@ -43,6 +231,150 @@ func (svc *Service) Run(wg *sync.WaitGroup) <-chan error {
 // It takes a context that it's passed to the underling HTTP server to control the shutdown process
 // and a *sync.WaitGroup to signal when the shutdown process is ended
 func (svc *Service) Shutdown(ctx context.Context, wg *sync.WaitGroup) error {
+	defer wg.Done()
+
+	svc.log.Println("shutdown")
+	defer svc.log.Println("bye")
+
+	// the /items streams end when the polling stops,
+	// otherwise the server would wait for them forever
+	if svc.stopPolling != nil {
+		svc.stopPolling()
+		<-svc.polling
+	}
+
+	err := svc.server.Shutdown(ctx)
+
+	// no more changes to the feeds after the server shutdown
+	if flushErr := svc.flushFeeds(); err == nil {
+		err = flushErr
+	}
+
+	return err
+}
+
+// loadFeeds loads the feeds from the store
+func (svc *Service) loadFeeds() error {
+	feeds, err := svc.store.Load()
+	if err != nil {
+		return fmt.Errorf("loading feeds: %w", err)
+	}
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	for _, feed := range feeds {
+		svc.feeds[feed.Name] = feed.URL
+		if feed.Category != "" {
+			svc.categories[feed.Name] = feed.Category
+		}
+	}
+	svc.loaded = true
+
+	return nil
+}
+
+// flushFeeds saves the feeds to the store
+func (svc *Service) flushFeeds() error {
+	svc.saveMu.Lock()
+	defer svc.saveMu.Unlock()
+
+	svc.mu.RLock()
+	loaded := svc.loaded
+	svc.mu.RUnlock()
+
+	if !loaded {
+		return nil
+	}
+
+	if err := svc.store.Save(svc.feedList()); err != nil {
+		return fmt.Errorf("saving feeds: %w", err)
+	}
+
+	return nil
+}
+
+// startPolling polls the feeds in background, publishing
+// their items to the /items streams
+func (svc *Service) startPolling() {
+	ctx, cancel := context.WithCancel(context.Background())
+	svc.stopPolling = cancel
+	svc.polling = make(chan struct{})
+
+	// without the items already streamed, they are streamed again
+	if err := svc.seen.Load(); err != nil {
+		svc.log.Printf("loading seen items: %v\n", err)
+	}
+
+	for _, feed := range svc.feedList() {
+		svc.scheduler.Add(feed.Name, feed.URL)
+	}
+
+	go svc.scheduler.Run(ctx)
+
+	go func() {
+		defer close(svc.polling)
+		defer svc.hub.close()
+
+		for update := range svc.scheduler.Updates() {
+			// the items are marked as seen only once published, so the ones
+			// not published yet when the service stops are streamed after the restart
+			svc.dedup.Mark(update.URL, svc.publish(update))
+
+			if err := svc.seen.Save(); err != nil {
+				svc.log.Printf("saving seen items: %v\n", err)
+			}
+		}
+	}()
+}
+
+// publish sends the items of update to the /items streams
+// It returns the items published
+func (svc *Service) publish(update rss.Update) []*gofeed.Item {
+	svc.mu.RLock()
+	category := svc.categories[update.Name]
+	svc.mu.RUnlock()
+
+	now := time.Now()
+
+	published := make([]*gofeed.Item, 0, len(update.Items))
+	for _, it := range update.Items {
+		news := News{
+			Feed:        update.Name,
+			Title:       it.Title,
+			Description: it.Description,
+			Content:     it.Content,
+			Link:        it.Link,
+			Published:   it.PublishedParsed,
+		}
+		if news.Published == nil {
+			news.Published = it.UpdatedParsed
+		}
+
+		data, err := json.Marshal(news)
+		if err != nil {
+			svc.log.Println(err)
+			continue
+		}
+
+		timestamp := now
+		if news.Published != nil {
+			timestamp = *news.Published
+		}
+
+		svc.hub.publish(item{
+			event: sse.Event{
+				ID:   itemID(update.Name, it),
+				Data: string(data),
+			},
+			news:      news,
+			category:  category,
+			timestamp: timestamp,
+		})
+		published = append(published, it)
+	}
+
+	return published
 }
 
 // DeadlineController holds a reference to the underlying TCP connection
@@ -53,27 +385,428 @@ type DeadlineController struct {
 	s *http.Server
 }
 
+// ContextKey is a custom type for our deadline controller key value
+type ContextKey string
+
+// DeadlineControllerKey is the name of the value passed through context
+const DeadlineControllerKey ContextKey = "deadline-controller"
+
+// NewDeadlineController returns a type that holds the underlying TCP connection
+// and a reference to the server
+func NewDeadlineController(c net.Conn, s *http.Server) *DeadlineController {
+	return &DeadlineController{
+		c: c,
+		s: s,
+	}
+}
+
+// ExtendWriteDeadline extends the write deadline on the underlying
+// stream-oriented connection by the server WriteTimeout
+// It does nothing if the server has no WriteTimeout
+func (dc *DeadlineController) ExtendWriteDeadline() error {
+	if dc.s == nil || dc.s.WriteTimeout == 0 {
+		return nil
+	}
+
+	return dc.c.SetWriteDeadline(time.Now().Add(dc.s.WriteTimeout))
+}
+
+// extendingWriter is a http.ResponseWriter that extends the write deadline
+// right before each Flush
+// It mirrors selective.ExtendingWriter of the timeouts server, that lives in another module
+// If the deadline can't be extended, the flush is dropped, the next writes
+// fail with the same error and abort is called, so that the handler stops streaming
+type extendingWriter struct {
+	http.ResponseWriter
+	dc    *DeadlineController
+	log   *log.Logger
+	abort context.CancelFunc
+
+	err error
+}
+
+// Write satisfies the http.ResponseWriter interface
+func (ew *extendingWriter) Write(p []byte) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+
+	return ew.ResponseWriter.Write(p)
+}
+
+// Flush satisfies the http.Flusher interface
+func (ew *extendingWriter) Flush() {
+	f, ok := ew.ResponseWriter.(http.Flusher)
+	if !ok || ew.err != nil {
+		return
+	}
+
+	if err := ew.dc.ExtendWriteDeadline(); err != nil {
+		ew.log.Printf("extending the write deadline: %v\n", err)
+		ew.err = err
+		ew.abort()
+		return
+	}
+
+	f.Flush()
+}
+
 // Feed holds information about a RSS feed
 type Feed struct {
-	Name string `json:"name"`
-	URL  string `json:"url"`
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Category string `json:"category,omitempty"`
 }
 
 // News holds the information to stream about a single item from a RSS feed
 type News struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Content     string `json:"content"`
+	Feed        string     `json:"feed"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Content     string     `json:"content"`
+	Link        string     `json:"link,omitempty"`
+	Published   *time.Time `json:"published,omitempty"`
+}
+
+// feedList returns the feeds sorted by name
+func (svc *Service) feedList() []Feed {
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+
+	feeds := make([]Feed, 0, len(svc.feeds))
+	for name, url := range svc.feeds {
+		feeds = append(feeds, Feed{Name: name, URL: url, Category: svc.categories[name]})
+	}
+
+	sort.Slice(feeds, func(i, j int) bool {
+		return feeds[i].Name < feeds[j].Name
+	})
+
+	return feeds
+}
+
+// itemID returns an ID for the item that is stable across fetches
+func itemID(feed string, item *gofeed.Item) string {
+	sum := sha1.Sum([]byte(feed + "\x00" + rss.ItemKey(item)))
+	return hex.EncodeToString(sum[:])
 }
 
 func (svc *Service) getFeeds(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(svc.feedList()); err != nil {
+		svc.log.Println(err)
+	}
+}
+
+// decodeFeed decodes the feed in the request body
+// If the body isn't valid, it replies with an error and returns false
+func (svc *Service) decodeFeed(w http.ResponseWriter, r *http.Request) (Feed, bool) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return Feed{}, false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	var feed Feed
+	if err := dec.Decode(&feed); err != nil {
+		// MaxBytesReader doesn't export its error
+		if err.Error() == "http: request body too large" {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return Feed{}, false
+		}
+
+		http.Error(w, "badly formed request body", http.StatusBadRequest)
+		return Feed{}, false
+	}
+
+	// the body must contain a single JSON object
+	if dec.More() {
+		http.Error(w, "request body must contain a single JSON object", http.StatusBadRequest)
+		return Feed{}, false
+	}
+
+	return feed, true
+}
+
+// validateFeedURL checks that rawURL is an absolute HTTP URL
+// It is used by all the ways of adding a feed: POST /feed, PUT /feed/{name} and the OPML import
+func validateFeedURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return errors.New("malformed url")
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
 
+	return nil
 }
 
 func (svc *Service) addFeed(w http.ResponseWriter, r *http.Request) {
+	feed, ok := svc.decodeFeed(w, r)
+	if !ok {
+		return
+	}
+
+	if feed.Name == "" || feed.URL == "" {
+		http.Error(w, "name and url are required", http.StatusBadRequest)
+		return
+	}
+	if err := validateFeedURL(feed.URL); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	svc.setFeed(feed)
+
+	if !svc.persistFeeds(w) {
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// putFeed replaces the feed in the path, or creates it if missing
+// If the body has a different name, the feed is renamed
+func (svc *Service) putFeed(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	feed, ok := svc.decodeFeed(w, r)
+	if !ok {
+		return
+	}
+
+	if feed.Name == "" {
+		feed.Name = name
+	}
+	if feed.URL == "" {
+		http.Error(w, "url is required", http.StatusBadRequest)
+		return
+	}
+	if err := validateFeedURL(feed.URL); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	svc.mu.Lock()
+
+	_, exists := svc.feeds[name]
+	if feed.Name != name {
+		if !exists {
+			svc.mu.Unlock()
+			http.Error(w, "feed not found", http.StatusNotFound)
+			return
+		}
+		if _, taken := svc.feeds[feed.Name]; taken {
+			svc.mu.Unlock()
+			http.Error(w, "a feed with the new name already exists", http.StatusConflict)
+			return
+		}
+
+		svc.removeFeed(name)
+	}
+	svc.storeFeed(feed)
+
+	svc.mu.Unlock()
+
+	if feed.Name != name {
+		svc.scheduler.Remove(name)
+	}
+	svc.scheduler.Add(feed.Name, feed.URL)
+
+	if !svc.persistFeeds(w) {
+		return
+	}
+
+	if !exists {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (svc *Service) deleteFeed(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	svc.mu.Lock()
+	_, exists := svc.feeds[name]
+	svc.removeFeed(name)
+	svc.mu.Unlock()
+
+	if !exists {
+		http.Error(w, "feed not found", http.StatusNotFound)
+		return
+	}
+
+	svc.scheduler.Remove(name)
+
+	if !svc.persistFeeds(w) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// persistFeeds saves the feeds after a change, so that it survives a crash
+// If the save fails it replies with an error and returns false: the change
+// is kept anyway, and saved along with the next one or during the shutdown
+func (svc *Service) persistFeeds(w http.ResponseWriter) bool {
+	if err := svc.flushFeeds(); err != nil {
+		svc.log.Println(err)
+		http.Error(w, "the feeds could not be saved", http.StatusInternalServerError)
+		return false
+	}
+
+	return true
+}
+
+// setFeed adds the feed, or replaces the one with the same name, and starts polling it
+func (svc *Service) setFeed(feed Feed) {
+	svc.mu.Lock()
+	svc.storeFeed(feed)
+	svc.mu.Unlock()
+
+	svc.scheduler.Add(feed.Name, feed.URL)
+}
+
+// addNewFeed adds the feed and starts polling it, unless a feed
+// with the same name exists: in that case it returns the existing one
+func (svc *Service) addNewFeed(feed Feed) (Feed, bool) {
+	svc.mu.Lock()
+	if url, ok := svc.feeds[feed.Name]; ok {
+		existing := Feed{Name: feed.Name, URL: url, Category: svc.categories[feed.Name]}
+		svc.mu.Unlock()
+		return existing, true
+	}
+	svc.storeFeed(feed)
+	svc.mu.Unlock()
+
+	svc.scheduler.Add(feed.Name, feed.URL)
+
+	return Feed{}, false
+}
+
+// storeFeed adds the feed, or replaces the one with the same name
+// svc.mu must be held
+func (svc *Service) storeFeed(feed Feed) {
+	url, ok := svc.feeds[feed.Name]
+	svc.feeds[feed.Name] = feed.URL
+	if ok && url != feed.URL {
+		svc.forget(url)
+	}
 
+	if feed.Category == "" {
+		delete(svc.categories, feed.Name)
+		return
+	}
+
+	if svc.categories == nil {
+		svc.categories = make(map[string]string)
+	}
+	svc.categories[feed.Name] = feed.Category
+}
+
+// removeFeed removes the feed with the specified name
+// svc.mu must be held
+func (svc *Service) removeFeed(name string) {
+	url, ok := svc.feeds[name]
+	delete(svc.feeds, name)
+	delete(svc.categories, name)
+	if ok {
+		svc.forget(url)
+	}
+}
+
+// forget drops the items of the feed at url kept by the aggregator,
+// unless another feed has the same url
+// svc.mu must be held
+func (svc *Service) forget(url string) {
+	for _, u := range svc.feeds {
+		if u == url {
+			return
+		}
+	}
+
+	svc.aggregator.Forget(url)
 }
 
 func (svc *Service) streamItems(w http.ResponseWriter, r *http.Request) {
+	// each event extends the write deadline, so the stream
+	// can last longer than the server WriteTimeout
+	if dc, ok := r.Context().Value(DeadlineControllerKey).(*DeadlineController); ok {
+		// a failed extension ends the stream, even if only heartbeats are sent
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		w = &extendingWriter{
+			ResponseWriter: w,
+			dc:             dc,
+			log:            svc.log,
+			abort:          cancel,
+		}
+	}
 
+	f, err := parseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sw, err := sse.NewWriter(w)
+	if err != nil {
+		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+		return
+	}
+
+	// keep the stream alive between the polls, and while there are no feeds:
+	// ending it would make the clients reconnect in a tight loop
+	stop := sw.Heartbeat(heartbeatInterval)
+	defer stop()
+
+	// the items are polled in background: send the recent ones first,
+	// or the ones missed by a resuming client, then the new ones as they come
+	// The event IDs are stable across the filters, so a filtered stream resumes as well
+	backlog, items, unsubscribe := svc.hub.subscribe(sse.LastEventID(r))
+	defer unsubscribe()
+
+	for _, it := range backlog {
+		if !f.match(it) {
+			continue
+		}
+
+		if err := sw.Send(it.event); err != nil {
+			svc.log.Println(err)
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case it, ok := <-items:
+			if !ok {
+				// service shutting down or stream too slow:
+				// the client can resume from the last event
+				return
+			}
+
+			if !f.match(it) {
+				continue
+			}
+
+			if err := sw.Send(it.event); err != nil {
+				svc.log.Println(err)
+				return
+			}
+		}
+	}
 }
@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
@@ -13,9 +14,16 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/Pippolo84/go-services-patterns/part1/timeouts/server/sse"
+	"github.com/Pippolo84/go-services-patterns/rss-service/rss"
+	"github.com/gorilla/mux"
+	"github.com/mmcdole/gofeed"
 )
 
 // test with `go test -v -race -timeout=30s .`
@@ -330,13 +338,15 @@ func TestFeedHandler(t *testing.T) {
 	defer f.Close()
 
 	svc := Service{
-		log:   log.New(f, "", log.LstdFlags),
-		feeds: map[string]string{},
+		log:        log.New(f, "", log.LstdFlags),
+		feeds:      map[string]string{},
+		scheduler:  rss.NewScheduler(nil, rss.SchedulerOptions{}),
+		aggregator: rss.NewAggregator(nil, rss.AggregatorOptions{}),
 	}
 
 	buf, err := json.Marshal(Feed{
 		Name: "test-1",
-		URL:  "test-url-1",
+		URL:  "http://example.com/test-url-1",
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -354,8 +364,57 @@ func TestFeedHandler(t *testing.T) {
 		t.Fatalf("expected status code %d, got %d\n", http.StatusAccepted, rr.Code)
 	}
 
-	if svc.feeds["test-1"] != "test-url-1" {
-		t.Fatalf("new feed (name %s, url %s) expected, got url %s\n", "test-1", "test-url-1", svc.feeds["test-1"])
+	if svc.feeds["test-1"] != "http://example.com/test-url-1" {
+		t.Fatalf("new feed (name %s, url %s) expected, got url %s\n", "test-1", "http://example.com/test-url-1", svc.feeds["test-1"])
+	}
+}
+
+func TestFeedHandlerInvalidURL(t *testing.T) {
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	testCases := []struct {
+		name string
+		url  string
+	}{
+		{name: "relative", url: "test-url-1"},
+		{name: "unsupported scheme", url: "ftp://example.com/rss"},
+		{name: "missing host", url: "http:///rss"},
+		{name: "malformed", url: "http://example.com/%zz"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := Service{
+				log:   log.New(f, "", log.LstdFlags),
+				feeds: map[string]string{},
+			}
+
+			buf, err := json.Marshal(Feed{
+				Name: "test-1",
+				URL:  tc.url,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, err := http.NewRequest(http.MethodPost, "/feed", bytes.NewReader(buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+
+			svc.addFeed(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("expected status code %d, got %d\n", http.StatusBadRequest, rr.Code)
+			}
+			if len(svc.feeds) != 0 {
+				t.Fatalf("expected no feeds, got %v\n", svc.feeds)
+			}
+		})
 	}
 }
 
@@ -367,8 +426,10 @@ func TestFeedHandlerConcurrentRequests(t *testing.T) {
 	defer f.Close()
 
 	svc := Service{
-		log:   log.New(f, "", log.LstdFlags),
-		feeds: map[string]string{},
+		log:        log.New(f, "", log.LstdFlags),
+		feeds:      map[string]string{},
+		scheduler:  rss.NewScheduler(nil, rss.SchedulerOptions{}),
+		aggregator: rss.NewAggregator(nil, rss.AggregatorOptions{}),
 	}
 
 	var wgStart, wgEnd sync.WaitGroup
@@ -381,7 +442,7 @@ func TestFeedHandlerConcurrentRequests(t *testing.T) {
 
 			buf, err := json.Marshal(Feed{
 				Name: "test-concurrent",
-				URL:  fmt.Sprintf("test-concurrent-url-%d", i),
+				URL:  fmt.Sprintf("http://example.com/test-concurrent-url-%d", i),
 			})
 			if err != nil {
 				t.Error(err)
@@ -409,6 +470,138 @@ func TestFeedHandlerConcurrentRequests(t *testing.T) {
 	wgEnd.Wait()
 }
 
+func TestPutFeedHandler(t *testing.T) {
+	testCases := []struct {
+		name     string
+		path     string
+		feed     Feed
+		code     int
+		expected map[string]string
+	}{
+		{
+			name:     "create",
+			path:     "test-3",
+			feed:     Feed{URL: "http://example.com/test-url-3"},
+			code:     http.StatusCreated,
+			expected: map[string]string{"test-1": "http://example.com/test-url-1", "test-2": "http://example.com/test-url-2", "test-3": "http://example.com/test-url-3"},
+		},
+		{
+			name:     "replace",
+			path:     "test-1",
+			feed:     Feed{Name: "test-1", URL: "http://example.com/test-url-1-new"},
+			code:     http.StatusNoContent,
+			expected: map[string]string{"test-1": "http://example.com/test-url-1-new", "test-2": "http://example.com/test-url-2"},
+		},
+		{
+			name:     "rename",
+			path:     "test-1",
+			feed:     Feed{Name: "test-3", URL: "http://example.com/test-url-1"},
+			code:     http.StatusNoContent,
+			expected: map[string]string{"test-2": "http://example.com/test-url-2", "test-3": "http://example.com/test-url-1"},
+		},
+		{
+			name:     "rename missing feed",
+			path:     "test-4",
+			feed:     Feed{Name: "test-3", URL: "http://example.com/test-url-3"},
+			code:     http.StatusNotFound,
+			expected: map[string]string{"test-1": "http://example.com/test-url-1", "test-2": "http://example.com/test-url-2"},
+		},
+		{
+			name:     "rename to existing feed",
+			path:     "test-1",
+			feed:     Feed{Name: "test-2", URL: "http://example.com/test-url-1"},
+			code:     http.StatusConflict,
+			expected: map[string]string{"test-1": "http://example.com/test-url-1", "test-2": "http://example.com/test-url-2"},
+		},
+		{
+			name:     "invalid url",
+			path:     "test-1",
+			feed:     Feed{Name: "test-1", URL: "test-url-1-new"},
+			code:     http.StatusBadRequest,
+			expected: map[string]string{"test-1": "http://example.com/test-url-1", "test-2": "http://example.com/test-url-2"},
+		},
+		{
+			name:     "missing url",
+			path:     "test-1",
+			feed:     Feed{Name: "test-1"},
+			code:     http.StatusBadRequest,
+			expected: map[string]string{"test-1": "http://example.com/test-url-1", "test-2": "http://example.com/test-url-2"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := os.Open(os.DevNull)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			svc := Service{
+				log:        log.New(f, "", log.LstdFlags),
+				feeds:      map[string]string{"test-1": "http://example.com/test-url-1", "test-2": "http://example.com/test-url-2"},
+				scheduler:  rss.NewScheduler(nil, rss.SchedulerOptions{}),
+				aggregator: rss.NewAggregator(nil, rss.AggregatorOptions{}),
+			}
+
+			buf, err := json.Marshal(tc.feed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, err := http.NewRequest(http.MethodPut, "/feed/"+tc.path, bytes.NewReader(buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req = mux.SetURLVars(req, map[string]string{"name": tc.path})
+			rr := httptest.NewRecorder()
+
+			svc.putFeed(rr, req)
+
+			if rr.Code != tc.code {
+				t.Fatalf("expected status code %d, got %d\n", tc.code, rr.Code)
+			}
+			if !reflect.DeepEqual(svc.feeds, tc.expected) {
+				t.Fatalf("expected feeds %v, got %v\n", tc.expected, svc.feeds)
+			}
+		})
+	}
+}
+
+func TestDeleteFeedHandler(t *testing.T) {
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	svc := Service{
+		log:        log.New(f, "", log.LstdFlags),
+		feeds:      map[string]string{"test-1": "test-url-1"},
+		categories: map[string]string{"test-1": "news"},
+		scheduler:  rss.NewScheduler(nil, rss.SchedulerOptions{}),
+		aggregator: rss.NewAggregator(nil, rss.AggregatorOptions{}),
+	}
+
+	for _, code := range []int{http.StatusNoContent, http.StatusNotFound} {
+		req, err := http.NewRequest(http.MethodDelete, "/feed/test-1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = mux.SetURLVars(req, map[string]string{"name": "test-1"})
+		rr := httptest.NewRecorder()
+
+		svc.deleteFeed(rr, req)
+
+		if rr.Code != code {
+			t.Fatalf("expected status code %d, got %d\n", code, rr.Code)
+		}
+	}
+
+	if len(svc.feeds) != 0 || len(svc.categories) != 0 {
+		t.Fatalf("expected no feeds, got %v\n", svc.feeds)
+	}
+}
+
 type MockedAddr struct{}
 
 func (ma MockedAddr) Network() string { return "" }
@@ -435,10 +628,14 @@ func TestItemsHandler(t *testing.T) {
 	svc := Service{
 		log:   log.New(f, "", log.LstdFlags),
 		feeds: map[string]string{},
+		hub:   newHub(10),
 	}
 
-	ctx := context.WithValue(
-		context.Background(),
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ctx = context.WithValue(
+		ctx,
 		DeadlineControllerKey,
 		NewDeadlineController(MockedConnection{}, svc.server),
 	)
@@ -452,7 +649,22 @@ func TestItemsHandler(t *testing.T) {
 	req = req.WithContext(ctx)
 	rr := httptest.NewRecorder()
 
-	svc.streamItems(rr, req)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		svc.streamItems(rr, req)
+	}()
+
+	// without feeds, the stream stays open anyway
+	select {
+	case <-done:
+		t.Fatal("expected the stream to stay open without feeds")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the client going away ends it
+	cancel()
+	<-done
 
 	if rr.Code != http.StatusOK {
 		t.Fatalf("expected status code %d, got %d\n", http.StatusAccepted, rr.Code)
@@ -467,7 +679,178 @@ func TestItemsHandler(t *testing.T) {
 	if rr.Header().Get("Connection") != "keep-alive" {
 		t.Fatalf("expected Connection %s, got %s\n", "keep-alive", rr.Header().Get("Connection"))
 	}
-	if rr.Header().Get("Transfer-Encoding") != "chunked" {
-		t.Fatalf("expected Transfer-Encoding %s, got %s\n", "chunked", rr.Header().Get("Transfer-Encoding"))
+	// net/http chunks the streamed response by itself
+	if rr.Header().Get("Transfer-Encoding") != "" {
+		t.Fatalf("expected no Transfer-Encoding header, got %s\n", rr.Header().Get("Transfer-Encoding"))
+	}
+}
+
+// failingDeadlineConnection is a connection whose write deadline can't be set
+type failingDeadlineConnection struct {
+	MockedConnection
+}
+
+func (fc failingDeadlineConnection) SetWriteDeadline(t time.Time) error {
+	return errors.New("set write deadline: use of closed network connection")
+}
+
+func TestItemsHandlerExtensionFailure(t *testing.T) {
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	svc := Service{
+		log: log.New(f, "", log.LstdFlags),
+		feeds: map[string]string{
+			"test": "test-url",
+		},
+		hub: newHub(10),
+	}
+
+	ctx := context.WithValue(
+		context.Background(),
+		DeadlineControllerKey,
+		NewDeadlineController(failingDeadlineConnection{}, &http.Server{WriteTimeout: time.Second}),
+	)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/items", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		svc.streamItems(rr, req)
+	}()
+
+	// the stream can't outlast the write deadline anymore: it ends at once
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		svc.hub.close()
+		<-done
+		t.Fatal("expected the stream to end when the write deadline can't be extended")
+	}
+}
+
+func TestItemsHandlerResume(t *testing.T) {
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	svc := Service{
+		log: log.New(f, "", log.LstdFlags),
+		feeds: map[string]string{
+			"test": "test-url",
+		},
+		hub: newHub(10),
+	}
+
+	svc.publish(rss.Update{
+		Name: "test",
+		URL:  "test-url",
+		Items: []*gofeed.Item{
+			{GUID: "1", Title: "first"},
+			{GUID: "2", Title: "second"},
+			{GUID: "3", Title: "third"},
+		},
+	})
+
+	// a new stream receives the recent items first
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/items", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+
+	svc.streamItems(rr, req)
+
+	if n := strings.Count(rr.Body.String(), "id: "); n != 3 {
+		t.Fatalf("expected 3 events, got %d\n", n)
+	}
+
+	// resume after the first item: only the two missed items are sent
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, "/items", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(sse.LastEventIDHeader, itemID("test", &gofeed.Item{GUID: "1"}))
+	rr = httptest.NewRecorder()
+
+	svc.streamItems(rr, req)
+
+	body := rr.Body.String()
+	if strings.Contains(body, `"title":"first"`) || strings.Count(body, "id: ") != 2 {
+		t.Fatalf("expected the two missed items to be replayed, got %q\n", body)
+	}
+}
+
+func TestItemsHandlerLive(t *testing.T) {
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	svc := Service{
+		log: log.New(f, "", log.LstdFlags),
+		feeds: map[string]string{
+			"test": "test-url",
+		},
+		hub: newHub(10),
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/items", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		svc.streamItems(rr, req)
+	}()
+
+	// wait for the stream to subscribe
+	for {
+		svc.hub.mu.Lock()
+		n := len(svc.hub.subs)
+		svc.hub.mu.Unlock()
+
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	svc.publish(rss.Update{
+		Name:  "test",
+		URL:   "test-url",
+		Items: []*gofeed.Item{{GUID: "1", Title: "first"}},
+	})
+
+	// closing the hub ends the stream
+	svc.hub.close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stream still running after closing the hub")
+	}
+
+	if !strings.Contains(rr.Body.String(), `"title":"first"`) {
+		t.Fatalf("expected the published item to be streamed, got %q\n", rr.Body.String())
 	}
 }
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/Pippolo84/go-services-patterns/rss-service/atomicfile"
+)
+
+// Store persists the feeds of the service
+// The service loads the feeds when it starts running, and saves them after each change
+// and during its shutdown
+type Store interface {
+	// Load returns the stored feeds
+	Load() ([]Feed, error)
+	// Save replaces the stored feeds with feeds
+	Save(feeds []Feed) error
+}
+
+// MemoryStore is a Store keeping the feeds in memory
+// It is safe to use concurrently
+type MemoryStore struct {
+	mu    sync.Mutex
+	feeds []Feed
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Load satisfies the Store interface
+func (ms *MemoryStore) Load() ([]Feed, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return append([]Feed(nil), ms.feeds...), nil
+}
+
+// Save satisfies the Store interface
+func (ms *MemoryStore) Save(feeds []Feed) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.feeds = append([]Feed(nil), feeds...)
+	return nil
+}
+
+// FileStore is a Store keeping the feeds in a JSON file
+// The file is replaced atomically: after a crash it holds
+// either the feeds saved before or the new ones, never a mix
+// It is safe to use concurrently
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileStore returns a FileStore keeping the feeds in the file at path
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Load satisfies the Store interface
+// A missing file holds no feeds
+func (fs *FileStore) Load() ([]Feed, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	buf, err := ioutil.ReadFile(fs.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var feeds []Feed
+	if err := json.Unmarshal(buf, &feeds); err != nil {
+		return nil, err
+	}
+
+	return feeds, nil
+}
+
+// Save satisfies the Store interface
+func (fs *FileStore) Save(feeds []Feed) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	buf, err := json.MarshalIndent(feeds, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.Write(fs.path, buf)
+}
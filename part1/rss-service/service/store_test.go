@@ -0,0 +1,180 @@
+// +build !integration
+
+package service
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStores(t *testing.T) {
+	feeds := []Feed{
+		{Name: "test-1", URL: "test-url-1"},
+		{Name: "test-2", URL: "test-url-2", Category: "news"},
+	}
+
+	testCases := []struct {
+		name  string
+		store Store
+	}{
+		{name: "memory", store: NewMemoryStore()},
+		{name: "file", store: NewFileStore(filepath.Join(t.TempDir(), "feeds.json"))},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			loaded, err := tc.store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(loaded) != 0 {
+				t.Fatalf("expected no feeds, got %d\n", len(loaded))
+			}
+
+			if err := tc.store.Save(feeds); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded, err = tc.store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(loaded, feeds) {
+				t.Fatalf("expected %v, got %v\n", feeds, loaded)
+			}
+		})
+	}
+}
+
+func TestFileStoreAtomicSave(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "feeds.json")
+
+	store := NewFileStore(path)
+	if err := store.Save([]Feed{{Name: "test-1", URL: "test-url-1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save([]Feed{{Name: "test-2", URL: "test-url-2"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// after a restart
+	loaded, err := NewFileStore(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded[0].Name != "test-2" {
+		t.Fatalf("expected only the last saved feed, got %v\n", loaded)
+	}
+
+	// no temporary files are left behind
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file, got %d\n", len(files))
+	}
+}
+
+func TestFileStoreCorrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feeds.json")
+	if err := ioutil.WriteFile(path, []byte("[{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewFileStore(path).Load(); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// the feeds are not overwritten by a service failing to load them
+	svc := NewService(":0", Options{Store: NewFileStore(path)})
+	if err := svc.loadFeeds(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := svc.flushFeeds(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "[{" {
+		t.Fatalf("expected the file to be untouched, got %q\n", buf)
+	}
+}
+
+func TestFeedsSavedOnChange(t *testing.T) {
+	store := NewMemoryStore()
+
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	svc := NewService(":0", Options{Store: store})
+	svc.log = log.New(f, "", log.LstdFlags)
+	if err := svc.loadFeeds(); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		expected []Feed
+	}{
+		{
+			name:     "add",
+			method:   http.MethodPost,
+			path:     "/feed",
+			body:     `{"name":"test-1","url":"http://example.com/1.xml"}`,
+			expected: []Feed{{Name: "test-1", URL: "http://example.com/1.xml"}},
+		},
+		{
+			name:     "rename",
+			method:   http.MethodPut,
+			path:     "/feed/test-1",
+			body:     `{"name":"test-2","url":"http://example.com/2.xml"}`,
+			expected: []Feed{{Name: "test-2", URL: "http://example.com/2.xml"}},
+		},
+		{
+			name:     "delete",
+			method:   http.MethodDelete,
+			path:     "/feed/test-2",
+			expected: []Feed{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+
+			svc.router.ServeHTTP(rr, req)
+
+			if rr.Code >= http.StatusBadRequest {
+				t.Fatalf("unexpected status code %d: %s\n", rr.Code, rr.Body.String())
+			}
+
+			// saved right away, not only during the shutdown
+			saved, err := store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved) != len(tc.expected) || (len(saved) > 0 && !reflect.DeepEqual(saved, tc.expected)) {
+				t.Fatalf("expected %v saved, got %v\n", tc.expected, saved)
+			}
+		})
+	}
+}
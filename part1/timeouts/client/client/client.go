@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Client Timeout: https://blog.cloudflare.com/the-complete-guide-to-golang-net-http-timeouts/
+
+const (
+	// TimeoutHeader carries the time left to the request deadline, in milliseconds
+	// It doesn't depend on the clocks of the client and the server being in sync
+	TimeoutHeader string = "X-Request-Timeout"
+	// DeadlineHeader carries the absolute request deadline, in RFC 3339 format
+	DeadlineHeader string = "X-Request-Deadline"
+
+	// keepAlive is the interval between keep-alive probes of the connections
+	keepAlive time.Duration = 30 * time.Second
+)
+
+// Timeouts holds the timeouts of each phase of a HTTP request
+// A zero value means no timeout for that phase, except for ExpectContinue
+type Timeouts struct {
+	// Dial limits the time to establish the TCP connection
+	Dial time.Duration
+	// TLSHandshake limits the time to complete the TLS handshake
+	TLSHandshake time.Duration
+	// ResponseHeader limits the time to receive the response headers,
+	// after the request has been fully written
+	ResponseHeader time.Duration
+	// ExpectContinue limits the time to wait for the server
+	// "100 Continue" response before sending the body anyway
+	// Unlike the other phases, a zero value doesn't wait at all:
+	// the body is sent right away, along with the headers
+	ExpectContinue time.Duration
+	// IdleConn is the time an idle connection is kept in the pool
+	IdleConn time.Duration
+	// Total limits the whole request, from dialing to reading the response body
+	Total time.Duration
+}
+
+// Options holds the optional settings of the client
+type Options struct {
+	// ExpectContinueAbove is the request body size above which the client
+	// asks the server for a "100 Continue" before sending the body
+	// A zero value disables it
+	ExpectContinueAbove int64
+}
+
+// NewClient returns a HTTP client configured with the specified timeouts
+// The client propagates the deadline of each request to the server
+// through the TimeoutHeader and DeadlineHeader headers
+func NewClient(timeouts Timeouts, opts Options) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	transport.DialContext = (&net.Dialer{
+		Timeout:   timeouts.Dial,
+		KeepAlive: keepAlive,
+	}).DialContext
+	transport.TLSHandshakeTimeout = timeouts.TLSHandshake
+	transport.ResponseHeaderTimeout = timeouts.ResponseHeader
+	transport.ExpectContinueTimeout = timeouts.ExpectContinue
+	transport.IdleConnTimeout = timeouts.IdleConn
+
+	return &http.Client{
+		Transport: &roundTripper{
+			next:                transport,
+			expectContinueAbove: opts.ExpectContinueAbove,
+			total:               timeouts.Total,
+		},
+		Timeout: timeouts.Total,
+	}
+}
+
+// WithBudget returns a copy of req that must complete within budget
+// The budget is propagated to the server, that can use it to give up
+// on the request when the client is not waiting for the response anymore
+// The returned cancel function must be called to release the resources
+func WithBudget(req *http.Request, budget time.Duration) (*http.Request, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(req.Context(), budget)
+	return req.WithContext(ctx), cancel
+}
+
+// roundTripper decorates a http.RoundTripper to propagate the request deadline
+// and to ask for a "100 Continue" before sending large bodies
+type roundTripper struct {
+	next                http.RoundTripper
+	expectContinueAbove int64
+	// total is the http.Client Timeout, that doesn't show up
+	// in the request context deadline
+	total time.Duration
+}
+
+// RoundTrip satisfies the http.RoundTripper interface
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// propagate the earliest between the context deadline and the client Timeout
+	deadline, hasDeadline := req.Context().Deadline()
+	if rt.total > 0 {
+		// the client Timeout started before this attempt (e.g. before a redirect),
+		// so this deadline can only be later than the real one, never earlier
+		if totalDeadline := time.Now().Add(rt.total); !hasDeadline || totalDeadline.Before(deadline) {
+			deadline, hasDeadline = totalDeadline, true
+		}
+	}
+	expectContinue := rt.expectContinueAbove > 0 && req.ContentLength > rt.expectContinueAbove
+
+	if hasDeadline || expectContinue {
+		// a RoundTripper must not modify the request
+		req = req.Clone(req.Context())
+	}
+
+	if hasDeadline {
+		// computed as late as possible, to account for the time already spent
+		left := time.Until(deadline)
+		if left < 0 {
+			left = 0
+		}
+
+		req.Header.Set(TimeoutHeader, strconv.FormatInt(left.Milliseconds(), 10))
+		req.Header.Set(DeadlineHeader, deadline.UTC().Format(time.RFC3339Nano))
+	}
+
+	if expectContinue {
+		req.Header.Set("Expect", "100-continue")
+	}
+
+	return rt.next.RoundTrip(req)
+}
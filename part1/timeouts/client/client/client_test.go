@@ -0,0 +1,237 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+		fmt.Fprint(w, "Hello, client!")
+	}))
+	defer ts.Close()
+
+	client := NewClient(Timeouts{
+		ResponseHeader: 100 * time.Millisecond,
+	}, Options{})
+
+	_, err := client.Get(ts.URL)
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected a timeout error, got: %v\n", err)
+	}
+}
+
+func TestTotalTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// send the headers right away, then stall the body
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		time.Sleep(time.Second)
+		fmt.Fprint(w, "Hello, client!")
+	}))
+	defer ts.Close()
+
+	client := NewClient(Timeouts{
+		ResponseHeader: 500 * time.Millisecond,
+		Total:          200 * time.Millisecond,
+	}, Options{})
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// the total timeout covers reading the body too
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(resp.Body)
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected a timeout error, got: %v\n", err)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	timeouts := make(chan string, 1)
+	deadlines := make(chan string, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeouts <- r.Header.Get(TimeoutHeader)
+		deadlines <- r.Header.Get(DeadlineHeader)
+	}))
+	defer ts.Close()
+
+	client := NewClient(Timeouts{}, Options{})
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	budget := 2 * time.Second
+	req, cancel := WithBudget(req, budget)
+	defer cancel()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	ms, err := strconv.ParseInt(<-timeouts, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := time.Duration(ms) * time.Millisecond; left <= 0 || left > budget {
+		t.Fatalf("expected a timeout within the budget %v, got %v\n", budget, left)
+	}
+
+	deadline, err := time.Parse(time.RFC3339Nano, <-deadlines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, _ := req.Context().Deadline(); !deadline.Equal(expected) {
+		t.Fatalf("expected deadline %v, got %v\n", expected, deadline)
+	}
+}
+
+func TestBudgetTotal(t *testing.T) {
+	timeouts := make(chan string, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeouts <- r.Header.Get(TimeoutHeader)
+	}))
+	defer ts.Close()
+
+	total := time.Second
+	client := NewClient(Timeouts{
+		Total: total,
+	}, Options{})
+
+	testCases := []struct {
+		name   string
+		budget time.Duration
+	}{
+		{
+			name: "no budget",
+		},
+		{
+			name:   "budget longer than total",
+			budget: 5 * time.Second,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.budget > 0 {
+				var cancel context.CancelFunc
+				req, cancel = WithBudget(req, tc.budget)
+				defer cancel()
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			// the server must not wait longer than the client Timeout
+			ms, err := strconv.ParseInt(<-timeouts, 10, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if left := time.Duration(ms) * time.Millisecond; left <= 0 || left > total {
+				t.Fatalf("expected a timeout within the client Timeout %v, got %v\n", total, left)
+			}
+		})
+	}
+}
+
+func TestBudgetExceeded(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+
+	client := NewClient(Timeouts{}, Options{})
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, cancel := WithBudget(req, 100*time.Millisecond)
+	defer cancel()
+
+	_, err = client.Do(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got: %v\n", context.DeadlineExceeded, err)
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	expects := make(chan string, 2)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expects <- r.Header.Get("Expect")
+	}))
+	defer ts.Close()
+
+	client := NewClient(Timeouts{
+		ExpectContinue: time.Second,
+	}, Options{
+		ExpectContinueAbove: 1024,
+	})
+
+	testCases := []struct {
+		name     string
+		size     int
+		expected string
+	}{
+		{
+			name:     "small body",
+			size:     16,
+			expected: "",
+		},
+		{
+			name:     "large body",
+			size:     4096,
+			expected: "100-continue",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := client.Post(ts.URL, "application/octet-stream", bytes.NewReader(make([]byte, tc.size)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if expect := <-expects; expect != tc.expected {
+				t.Fatalf("expected Expect header %q, got %q\n", tc.expected, expect)
+			}
+		})
+	}
+}
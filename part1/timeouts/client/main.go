@@ -62,3 +62,45 @@ func main() {
 	}
 	fmt.Println(string(buf))
 }
+
+// Use the client in the "client" package to set a timeout for each phase of the request
+// and to propagate the time budget of the request to the server
+
+// func main() {
+// 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+// 		fmt.Println("time left to respond:", r.Header.Get(client.TimeoutHeader), "ms")
+// 		time.Sleep(2 * time.Second)
+// 		fmt.Fprint(w, "Hello, client!")
+// 	}))
+
+// 	c := client.NewClient(client.Timeouts{
+// 		Dial:           time.Second,
+// 		TLSHandshake:   time.Second,
+// 		ResponseHeader: 3 * time.Second,
+// 		ExpectContinue: time.Second,
+// 		IdleConn:       time.Minute,
+// 		Total:          5 * time.Second,
+// 	}, client.Options{
+// 		ExpectContinueAbove: 1024 * 1024,
+// 	})
+
+// 	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+// 	if err != nil {
+// 		panic(err)
+// 	}
+
+// 	req, cancel := client.WithBudget(req, time.Second)
+// 	defer cancel()
+
+// 	resp, err := c.Do(req)
+// 	if err != nil {
+// 		panic(err)
+// 	}
+// 	defer resp.Body.Close()
+
+// 	buf, err := ioutil.ReadAll(resp.Body)
+// 	if err != nil {
+// 		panic(err)
+// 	}
+// 	fmt.Println(string(buf))
+// }
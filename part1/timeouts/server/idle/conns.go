@@ -0,0 +1,294 @@
+package idle
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Limits holds the limits on the connections of the Server
+type Limits struct {
+	// MaxConns is the maximum number of concurrent connections, zero means no limit
+	// Hijacked connections count until they are closed
+	MaxConns int
+	// Queue makes the connections beyond MaxConns wait in the listen backlog
+	// until a connection is closed, instead of being closed right away
+	Queue bool
+}
+
+// ConnStats holds a snapshot of the connections of the Server
+type ConnStats struct {
+	// New, Active, Idle and Hijacked are the number of open connections in each state
+	New      int
+	Active   int
+	Idle     int
+	Hijacked int
+
+	// Closed is the total number of connections closed so far
+	Closed uint64
+	// Rejected is the total number of connections closed because beyond MaxConns
+	Rejected uint64
+
+	// Oldest is the age of the oldest open connection
+	Oldest time.Duration
+}
+
+// ConnInfo holds the information about an open connection
+type ConnInfo struct {
+	RemoteAddr string
+	State      http.ConnState
+	Age        time.Duration
+}
+
+type connEntry struct {
+	state    http.ConnState
+	accepted time.Time
+}
+
+// tracker keeps the state of each connection, updated through http.Server.ConnState
+type tracker struct {
+	mu       sync.Mutex
+	conns    map[net.Conn]*connEntry
+	closed   uint64
+	rejected uint64
+}
+
+func newTracker() *tracker {
+	return &tracker{
+		conns: make(map[net.Conn]*connEntry),
+	}
+}
+
+func (t *tracker) add(c net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.conns[c] = &connEntry{
+		state:    http.StateNew,
+		accepted: time.Now(),
+	}
+}
+
+func (t *tracker) remove(c net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.conns, c)
+	t.closed++
+}
+
+func (t *tracker) reject() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rejected++
+}
+
+// connState satisfies the http.Server.ConnState signature
+// Closed connections are removed when the connection itself is closed,
+// since the server doesn't report the hijacked ones
+func (t *tracker) connState(c net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.conns[c]; ok && state != http.StateClosed {
+		e.state = state
+	}
+}
+
+func (t *tracker) stats() ConnStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := ConnStats{
+		Closed:   t.closed,
+		Rejected: t.rejected,
+	}
+
+	now := time.Now()
+	for _, e := range t.conns {
+		switch e.state {
+		case http.StateNew:
+			stats.New++
+		case http.StateActive:
+			stats.Active++
+		case http.StateIdle:
+			stats.Idle++
+		case http.StateHijacked:
+			stats.Hijacked++
+		}
+
+		if age := now.Sub(e.accepted); age > stats.Oldest {
+			stats.Oldest = age
+		}
+	}
+
+	return stats
+}
+
+func (t *tracker) list() []ConnInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	conns := make([]ConnInfo, 0, len(t.conns))
+	for c, e := range t.conns {
+		conns = append(conns, ConnInfo{
+			RemoteAddr: c.RemoteAddr().String(),
+			State:      e.state,
+			Age:        now.Sub(e.accepted),
+		})
+	}
+
+	// oldest first
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].Age > conns[j].Age
+	})
+
+	return conns
+}
+
+// limitListener tracks each accepted connection and enforces the Limits
+// see golang.org/x/net/netutil.LimitListener
+type limitListener struct {
+	net.Listener
+	limits  Limits
+	tracker *tracker
+
+	sem       chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newLimitListener(l net.Listener, limits Limits, t *tracker) *limitListener {
+	ll := &limitListener{
+		Listener: l,
+		limits:   limits,
+		tracker:  t,
+		done:     make(chan struct{}),
+	}
+
+	if limits.MaxConns > 0 {
+		ll.sem = make(chan struct{}, limits.MaxConns)
+	}
+
+	return ll
+}
+
+// acquire blocks until a slot is free or the listener is closed
+func (l *limitListener) acquire() bool {
+	select {
+	case <-l.done:
+		return false
+	case l.sem <- struct{}{}:
+		return true
+	}
+}
+
+// tryAcquire takes a slot only if one is free
+func (l *limitListener) tryAcquire() bool {
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *limitListener) release() {
+	<-l.sem
+}
+
+// Accept satisfies the net.Listener interface
+func (l *limitListener) Accept() (net.Conn, error) {
+	if l.sem == nil {
+		return l.accept(false)
+	}
+
+	if l.limits.Queue {
+		// leave the connections in the listen backlog until a slot is free
+		acquired := l.acquire()
+
+		// if the listener has been closed, Accept returns an error anyway
+		c, err := l.Listener.Accept()
+		if err != nil {
+			if acquired {
+				l.release()
+			}
+			return nil, err
+		}
+
+		return l.track(c, acquired), nil
+	}
+
+	for {
+		c, err := l.accept(true)
+		if err != nil || c != nil {
+			return c, err
+		}
+	}
+}
+
+// accept accepts a connection, closing it and returning a nil one
+// if limited is true and there are no free slots
+func (l *limitListener) accept(limited bool) (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !limited {
+		return l.track(c, false), nil
+	}
+
+	if !l.tryAcquire() {
+		c.Close()
+		l.tracker.reject()
+		return nil, nil
+	}
+
+	return l.track(c, true), nil
+}
+
+func (l *limitListener) track(c net.Conn, acquired bool) net.Conn {
+	tc := &trackedConn{
+		Conn:     c,
+		listener: l,
+		acquired: acquired,
+	}
+	l.tracker.add(tc)
+
+	return tc
+}
+
+// Close satisfies the net.Listener interface
+func (l *limitListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() { close(l.done) })
+	return err
+}
+
+// trackedConn releases its slot and leaves the tracker when closed
+// The slot is released first, so that a connection counted as closed
+// has always made room for a new one
+type trackedConn struct {
+	net.Conn
+	listener *limitListener
+	acquired bool
+
+	closeOnce sync.Once
+}
+
+// Close satisfies the net.Conn interface
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		if c.acquired {
+			c.listener.release()
+		}
+		c.listener.tracker.remove(c)
+	})
+	return err
+}
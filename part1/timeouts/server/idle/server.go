@@ -1,12 +1,11 @@
 package idle
 
 import (
-	"context"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
-	"sync"
 	"time"
 )
 
@@ -17,25 +16,36 @@ type Timeouts struct {
 
 // Server is a HTTP server with configurable idle timeout
 // and some defaults handlers to experiment with them
+// It keeps track of the state of its connections and
+// can limit the number of concurrent ones
 type Server struct {
 	http.Server
+
+	limits Limits
+	conns  *tracker
 }
 
 // NewServer returns a HTTP server listening on addr
-// and configured with the specified timeouts
-func NewServer(addr string, timeouts Timeouts) *Server {
+// and configured with the specified timeouts and connection limits
+func NewServer(addr string, timeouts Timeouts, limits Limits) *Server {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/", handler)
 
+	conns := newTracker()
+
 	return &Server{
-		http.Server{
+		Server: http.Server{
 			Addr: addr,
 
 			IdleTimeout: timeouts.Idle,
 
 			Handler: mux,
+
+			ConnState: conns.connState,
 		},
+		limits: limits,
+		conns:  conns,
 	}
 }
 
@@ -46,7 +56,14 @@ func (srv *Server) Run() <-chan error {
 
 	go func() {
 		defer close(errs)
-		if err := srv.ListenAndServe(); err != nil {
+
+		ln, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			errs <- err
+			return
+		}
+
+		if err := srv.Serve(newLimitListener(ln, srv.limits, srv.conns)); err != nil {
 			errs <- err
 		}
 	}()
@@ -54,12 +71,14 @@ func (srv *Server) Run() <-chan error {
 	return errs
 }
 
-// Shutdown makes the server stop listening and refuse further connections
-// It takes a context to limit the shutdown duration and a wait group to signal
-// the caller when the shutdown process has finished
-func (srv *Server) Shutdown(ctx context.Context, wg *sync.WaitGroup) error {
-	defer wg.Done()
-	return srv.Server.Shutdown(ctx)
+// ConnStats returns a snapshot of the connections of the server
+func (srv *Server) ConnStats() ConnStats {
+	return srv.conns.stats()
+}
+
+// Conns returns the open connections of the server, oldest first
+func (srv *Server) Conns() []ConnInfo {
+	return srv.conns.list()
 }
 
 func handler(w http.ResponseWriter, r *http.Request) {
@@ -9,17 +9,16 @@ import (
 	"net"
 	"net/http"
 	"strings"
-	"sync"
 	"testing"
 	"time"
 )
 
 // go test -v -timeout=20s .
 
-func startServer(t *testing.T, addr string, timeouts Timeouts) (*Server, <-chan error) {
+func startServer(t *testing.T, addr string, timeouts Timeouts, limits Limits) (*Server, <-chan error) {
 	t.Helper()
 
-	srv := NewServer(addr, timeouts)
+	srv := NewServer(addr, timeouts, limits)
 
 	errs := make(chan error)
 
@@ -33,8 +32,10 @@ func startServer(t *testing.T, addr string, timeouts Timeouts) (*Server, <-chan
 
 	// wait for the server to listen on addr
 	for {
-		_, err := net.Dial("tcp", fmt.Sprintf("localhost%s", addr))
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost%s", addr))
 		if err == nil {
+			// don't count the probe among the connections under test
+			conn.Close()
 			break
 		}
 		if strings.Contains(err.Error(), "connection refused") {
@@ -51,7 +52,7 @@ func startServer(t *testing.T, addr string, timeouts Timeouts) (*Server, <-chan
 func TestIdleTimeout(t *testing.T) {
 	srv, srvErrs := startServer(t, ":8080", Timeouts{
 		Idle: 2 * time.Second,
-	})
+	}, Limits{})
 
 	getRequest := func() string {
 		t.Helper()
@@ -83,14 +84,44 @@ func TestIdleTimeout(t *testing.T) {
 		t.Fatal("request 3 unexpectedly on the same TCP connection")
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(1)
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
 
-	if err := srv.Shutdown(context.Background(), &wg); err != nil {
+	// srvErrs should have been closed without errors
+	for err := range srvErrs {
+		if errors.Is(err, http.ErrServerClosed) {
+			continue
+		}
 		t.Fatal(err)
 	}
+}
 
-	wg.Wait()
+// waitStats polls the server until its connection stats satisfy cond
+func waitStats(t *testing.T, srv *Server, cond func(ConnStats) bool) ConnStats {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		stats := srv.ConnStats()
+		if cond(stats) {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected connection stats: %+v\n", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// stopServer shuts the server down, it's registered with t.Cleanup so that
+// a failing test doesn't leave its address bound for the following ones
+func stopServer(t *testing.T, srv *Server, srvErrs <-chan error) {
+	t.Helper()
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
 
 	// srvErrs should have been closed without errors
 	for err := range srvErrs {
@@ -100,3 +131,123 @@ func TestIdleTimeout(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestConnStats(t *testing.T) {
+	srv, srvErrs := startServer(t, ":8091", Timeouts{}, Limits{})
+	t.Cleanup(func() { stopServer(t, srv, srvErrs) })
+
+	// wait for the probe connection to be closed
+	waitStats(t, srv, func(s ConnStats) bool { return s.Closed >= 1 })
+
+	// a connection that doesn't send anything stays new
+	conn, err := net.Dial("tcp", "localhost:8091")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitStats(t, srv, func(s ConnStats) bool { return s.New == 1 })
+
+	// a kept-alive connection becomes idle after the response
+	client := &http.Client{Transport: &http.Transport{}}
+	res, err := client.Get("http://localhost:8091/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(res.Body); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	stats := waitStats(t, srv, func(s ConnStats) bool { return s.New == 1 && s.Idle == 1 })
+	if stats.Oldest <= 0 {
+		t.Fatalf("expected the age of the oldest connection, got %v\n", stats.Oldest)
+	}
+
+	if n := len(srv.Conns()); n != 2 {
+		t.Fatalf("expected 2 open connections, got %d\n", n)
+	}
+
+	conn.Close()
+	client.CloseIdleConnections()
+
+	waitStats(t, srv, func(s ConnStats) bool { return s.New == 0 && s.Idle == 0 && s.Closed >= 3 })
+}
+
+func TestMaxConnsClose(t *testing.T) {
+	srv, srvErrs := startServer(t, ":8092", Timeouts{}, Limits{MaxConns: 1})
+	t.Cleanup(func() { stopServer(t, srv, srvErrs) })
+
+	// wait for the probe connection to be closed and to free its slot
+	waitStats(t, srv, func(s ConnStats) bool { return s.Closed >= 1 })
+
+	first, err := net.Dial("tcp", "localhost:8092")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	waitStats(t, srv, func(s ConnStats) bool { return s.New == 1 })
+
+	// the second connection is closed right away
+	second, err := net.Dial("tcp", "localhost:8092")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	if err := second.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the connection beyond the limit to be closed")
+	}
+
+	waitStats(t, srv, func(s ConnStats) bool { return s.Rejected == 1 })
+
+	first.Close()
+}
+
+func TestMaxConnsQueue(t *testing.T) {
+	srv, srvErrs := startServer(t, ":8093", Timeouts{}, Limits{MaxConns: 1, Queue: true})
+	t.Cleanup(func() { stopServer(t, srv, srvErrs) })
+
+	// wait for the probe connection to be closed and to free its slot
+	waitStats(t, srv, func(s ConnStats) bool { return s.Closed >= 1 })
+
+	first, err := net.Dial("tcp", "localhost:8093")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	waitStats(t, srv, func(s ConnStats) bool { return s.New == 1 })
+
+	// the second request waits for the first connection to be closed
+	done := make(chan error, 1)
+	go func() {
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		res, err := client.Get("http://localhost:8093/")
+		if err == nil {
+			res.Body.Close()
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected the request to be queued, got: %v\n", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	first.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the queued request to be served")
+	}
+
+	if stats := srv.ConnStats(); stats.Rejected != 0 {
+		t.Fatalf("expected no rejected connections, got %d\n", stats.Rejected)
+	}
+}
@@ -9,12 +9,11 @@ import (
 )
 
 // Use the server in the "streaming" package to show that the WriteTimeout is
-// not compatible with a streaming server:
-// the "/streaming" endpoint will be closed before finishing the streaming
+// compatible with a streaming server, as long as the write deadline is extended:
+// the "/streaming" endpoint extends it on each flush, so it finishes the streaming
+// despite lasting longer than the WriteTimeout
 
 func main() {
-	// Despite the "Connection":"keep-alive" header, due to WriteTimeout,
-	// the connection will be closed.
 	srv := streaming.NewServer(":8080", streaming.Timeouts{
 		Write: 2 * time.Second,
 	})
@@ -40,3 +39,7 @@ func main() {
 // 		log.Fatal(err)
 // 	}
 // }
+
+// Take a look at the server in the "unified" package: each route declares its
+// own timeouts policy on top of the server defaults
+// ExampleNewServer in unified/example_test.go shows how to run it
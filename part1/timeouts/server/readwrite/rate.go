@@ -0,0 +1,270 @@
+package readwrite
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ErrTooSlow is returned when the client transfer rate is below the minimum
+var ErrTooSlow = errors.New("readwrite: transfer rate below the minimum")
+
+// connContextKey is the key of the underlying connection in the request context
+type connContextKey struct{}
+
+// withConn stores the underlying connection in the connection context
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// MinRate holds the minimum transfer rates, in bytes per second, required to the clients
+// Unlike ReadTimeout and WriteTimeout, the time allowed grows with the size
+// of the transfer: a large upload on a slow link is not cut off, while a client
+// trickling a few bytes at a time is dropped as soon as it falls behind
+type MinRate struct {
+	// Read is the minimum rate to receive the request body, zero means no minimum
+	Read int64
+	// Write is the minimum rate to send the response, zero means no minimum
+	Write int64
+	// Grace is the time allowed before the rates are enforced
+	Grace time.Duration
+}
+
+// RateCounters counts the connections dropped because too slow,
+// separately from the ordinary timeouts
+// It is safe to use concurrently
+type RateCounters struct {
+	slowReads  uint64
+	slowWrites uint64
+}
+
+// SlowReads returns the number of connections dropped while receiving the request body
+func (rc *RateCounters) SlowReads() uint64 {
+	return atomic.LoadUint64(&rc.slowReads)
+}
+
+// SlowWrites returns the number of connections dropped while sending the response
+func (rc *RateCounters) SlowWrites() uint64 {
+	return atomic.LoadUint64(&rc.slowWrites)
+}
+
+// maxAllowed caps the time allowed by the rates to a transfer,
+// so that the deadlines don't overflow
+const maxAllowed time.Duration = 100 * 365 * 24 * time.Hour
+
+// rateDeadline returns the time by which the transfer of n bytes must be
+// completed to keep the minimum rate, for an I/O call starting at now
+// Only the time already spent blocked in I/O (busy) counts against the client:
+// the time the handler takes between two calls doesn't
+func rateDeadline(now time.Time, busy, grace time.Duration, n, rate int64) time.Time {
+	// in floating point, not to overflow with large transfers
+	allowed := float64(n) / float64(rate) * float64(time.Second)
+	if allowed > float64(maxAllowed) {
+		allowed = float64(maxAllowed)
+	}
+
+	return now.Add(grace + time.Duration(allowed) - busy)
+}
+
+// serverTimeouts returns the ReadTimeout and WriteTimeout of the server of r
+func serverTimeouts(r *http.Request) (time.Duration, time.Duration) {
+	srv, ok := r.Context().Value(http.ServerContextKey).(*http.Server)
+	if !ok {
+		return 0, 0
+	}
+
+	return srv.ReadTimeout, srv.WriteTimeout
+}
+
+// after returns the deadline of a timeout starting now, no deadline if zero
+func after(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(timeout)
+}
+
+// isTimeout reports whether err is due to an expired deadline
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// MinRateHandler returns a http.Handler that enforces the minimum transfer rates on next
+// The rates are enforced moving the deadlines of the underlying connection,
+// overriding the server ReadTimeout and WriteTimeout for the request
+// A connection falling below the rate is closed and counted in counters
+func MinRateHandler(next http.Handler, rate MinRate, counters *RateCounters) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, ok := r.Context().Value(connContextKey{}).(net.Conn)
+		if !ok {
+			// no connection to control (e.g. in tests using a recorder)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// the deadlines moved by the rates are put back to the server timeouts
+		// once done, counting from then, since the rates may have allowed
+		// the request to last longer than them
+		readTimeout, writeTimeout := serverTimeouts(r)
+
+		if rate.Read > 0 {
+			rr := &rateReader{
+				ReadCloser: r.Body,
+				conn:       conn,
+				rate:       rate,
+				counters:   counters,
+			}
+			r.Body = rr
+
+			defer func() {
+				if rr.moved {
+					_ = conn.SetReadDeadline(after(readTimeout))
+				}
+			}()
+		}
+
+		if rate.Write > 0 {
+			rw := &rateWriter{
+				ResponseWriter: w,
+				conn:           conn,
+				rate:           rate,
+				counters:       counters,
+			}
+			w = rw
+
+			defer func() {
+				// the server writes what's left in its buffer after the handler
+				// returns: do it now, while the rate is still enforced
+				rw.Flush()
+				_ = conn.SetWriteDeadline(after(writeTimeout))
+			}()
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateReader drops the connection if the request body is received too slowly
+// The rate is measured on the time spent blocked in Read, so that the time
+// spent by the handler between two reads doesn't count
+type rateReader struct {
+	io.ReadCloser
+	conn     net.Conn
+	busy     time.Duration
+	rate     MinRate
+	counters *RateCounters
+
+	read int64
+	slow bool
+	// moved is set once the read deadline has been moved
+	moved bool
+}
+
+// Read satisfies the io.Reader interface
+func (rr *rateReader) Read(p []byte) (int, error) {
+	if rr.slow {
+		return 0, ErrTooSlow
+	}
+
+	// without new data, the rate falls below the minimum at this deadline
+	start := time.Now()
+	deadline := rateDeadline(start, rr.busy, rr.rate.Grace, rr.read, rr.rate.Read)
+	if err := rr.conn.SetReadDeadline(deadline); err != nil {
+		return 0, err
+	}
+	rr.moved = true
+
+	n, err := rr.ReadCloser.Read(p)
+	rr.read += int64(n)
+	rr.busy += time.Since(start)
+
+	if err != nil && isTimeout(err) {
+		rr.slow = true
+		atomic.AddUint64(&rr.counters.slowReads, 1)
+		rr.conn.Close()
+		return n, ErrTooSlow
+	}
+
+	return n, err
+}
+
+// rateWriter drops the connection if the response is sent too slowly
+// The rate is measured on the time spent blocked in Write and Flush, so that
+// the time spent by the handler to prepare the response, or between two writes,
+// doesn't count
+type rateWriter struct {
+	http.ResponseWriter
+	conn     net.Conn
+	busy     time.Duration
+	rate     MinRate
+	counters *RateCounters
+
+	written int64
+	slow    bool
+}
+
+// extend sets the write deadline to send n more bytes at the minimum rate,
+// for a write starting at now, and returns it
+func (rw *rateWriter) extend(now time.Time, n int) (time.Time, error) {
+	deadline := rateDeadline(now, rw.busy, rw.rate.Grace, rw.written+int64(n), rw.rate.Write)
+	return deadline, rw.conn.SetWriteDeadline(deadline)
+}
+
+// drop closes the connection of a client too slow
+func (rw *rateWriter) drop() {
+	rw.slow = true
+	atomic.AddUint64(&rw.counters.slowWrites, 1)
+	rw.conn.Close()
+}
+
+// Write satisfies the http.ResponseWriter interface
+func (rw *rateWriter) Write(p []byte) (int, error) {
+	if rw.slow {
+		return 0, ErrTooSlow
+	}
+
+	start := time.Now()
+	if _, err := rw.extend(start, len(p)); err != nil {
+		return 0, err
+	}
+
+	n, err := rw.ResponseWriter.Write(p)
+	rw.written += int64(n)
+	rw.busy += time.Since(start)
+
+	if err != nil && isTimeout(err) {
+		rw.drop()
+		return n, ErrTooSlow
+	}
+
+	return n, err
+}
+
+// Flush satisfies the http.Flusher interface
+func (rw *rateWriter) Flush() {
+	f, ok := rw.ResponseWriter.(http.Flusher)
+	if !ok || rw.slow {
+		return
+	}
+
+	start := time.Now()
+	deadline, err := rw.extend(start, 0)
+	if err != nil {
+		return
+	}
+
+	f.Flush()
+	rw.busy += time.Since(start)
+
+	// Flush doesn't report the errors: a flush ending past the
+	// deadline means the connection has timed out
+	if time.Now().After(deadline) {
+		rw.drop()
+	}
+}
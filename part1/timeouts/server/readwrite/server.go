@@ -1,10 +1,12 @@
 package readwrite
 
 import (
-	"context"
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
 	"net/http"
-	"sync"
 	"time"
 )
 
@@ -16,35 +18,61 @@ type Timeouts struct {
 	Write time.Duration
 
 	Handler time.Duration
+
+	// MinRate is enforced on the "/upload" and "/download" endpoints
+	MinRate MinRate
 }
 
 // Server is a HTTP server with configurable timeouts
 // and some defaults handlers to experiment with them
 type Server struct {
 	http.Server
+
+	overruns *Overruns
+	rates    *RateCounters
 }
 
 // NewServer returns a HTTP server listening on addr
 // and configured with the specified timeouts
 func NewServer(addr string, timeouts Timeouts) *Server {
 	mux := http.NewServeMux()
+	overruns := &Overruns{}
+	rates := &RateCounters{}
 
 	mux.HandleFunc("/", handler)
-	mux.Handle("/slow", http.TimeoutHandler(http.HandlerFunc(slowHandler), timeouts.Handler, "timeout"))
+	mux.Handle("/slow", TimeoutHandler(http.HandlerFunc(slowHandler), timeouts.Handler, overruns))
+	mux.Handle("/streaming", TimeoutHandler(http.HandlerFunc(streamingHandler), timeouts.Handler, overruns))
 	mux.HandleFunc("/timeout", timeoutHandler)
+	mux.Handle("/upload", MinRateHandler(http.HandlerFunc(uploadHandler), timeouts.MinRate, rates))
+	mux.Handle("/download", MinRateHandler(http.HandlerFunc(downloadHandler), timeouts.MinRate, rates))
 
 	return &Server{
-		http.Server{
+		Server: http.Server{
 			Addr: addr,
 
 			ReadTimeout:  timeouts.Read,
 			WriteTimeout: timeouts.Write,
 
 			Handler: mux,
+
+			// the minimum rates are enforced through the connection deadlines
+			ConnContext: withConn,
 		},
+		overruns: overruns,
+		rates:    rates,
 	}
 }
 
+// Overruns returns the handlers that kept running after their deadline
+func (srv *Server) Overruns() []Overrun {
+	return srv.overruns.List()
+}
+
+// RateCounters returns the counters of the connections dropped because too slow
+func (srv *Server) RateCounters() *RateCounters {
+	return srv.rates
+}
+
 // Run starts the server, making it listening on specified address
 // it returns a channel where all errors are relayed
 func (srv *Server) Run() <-chan error {
@@ -60,26 +88,62 @@ func (srv *Server) Run() <-chan error {
 	return errs
 }
 
-// Shutdown makes the server stop listening and refuse further connections
-// It takes a context to limit the shutdown duration and a wait group to signal
-// the caller when the shutdown process has finished
-func (srv *Server) Shutdown(ctx context.Context, wg *sync.WaitGroup) error {
-	defer wg.Done()
-	return srv.Server.Shutdown(ctx)
-}
-
 func handler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Hello, world!")
 }
 
 func slowHandler(w http.ResponseWriter, r *http.Request) {
+	// this ignores the context cancellation, so it will be recorded as an overrun
 	time.Sleep(2 * time.Second)
 	fmt.Fprintf(w, "Hello, slow world!")
 }
 
+func streamingHandler(w http.ResponseWriter, r *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+		return
+	}
+
+	// stream until the handler deadline
+	for {
+		fmt.Fprintln(w, "Hello, streaming world!")
+		f.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
 func timeoutHandler(w http.ResponseWriter, r *http.Request) {
 	// Despite returning a io.EOF on client side, the handler won't stop execution.
 	// Check the duration of TestWriteTimeout test to prove it!
 	time.Sleep(6 * time.Second)
 	fmt.Fprintf(w, "Hello, timeout world!")
 }
+
+func uploadHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := io.Copy(ioutil.Discard, r.Body)
+	if err != nil {
+		// the connection of a client too slow has already been closed
+		log.Println(err)
+		return
+	}
+
+	fmt.Fprintf(w, "%d", n)
+}
+
+func downloadHandler(w http.ResponseWriter, r *http.Request) {
+	// large enough to fill the socket buffers of a client not reading it
+	chunk := bytes.Repeat([]byte("Hello, download world!\n"), 1024)
+
+	for i := 0; i < 1024; i++ {
+		if _, err := w.Write(chunk); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+}
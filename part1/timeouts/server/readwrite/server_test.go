@@ -1,11 +1,17 @@
 package readwrite
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
@@ -19,15 +25,27 @@ const (
 	SrvWriteTimeout time.Duration = 5 * time.Second
 
 	HandlerTimeout time.Duration = time.Second
+
+	MinReadRate  int64         = 1024
+	MinWriteRate int64         = 10 * 1024 * 1024
+	RateGrace    time.Duration = time.Second
 )
 
+var srv *Server
+
 func TestMain(m *testing.M) {
 	// create and run the server under test
-	srv := NewServer(":8080", Timeouts{
+	srv = NewServer(":8080", Timeouts{
 		Read:  SrvReadTimeout,
 		Write: SrvWriteTimeout,
 
 		Handler: HandlerTimeout,
+
+		MinRate: MinRate{
+			Read:  MinReadRate,
+			Write: MinWriteRate,
+			Grace: RateGrace,
+		},
 	})
 
 	go func() {
@@ -92,6 +110,133 @@ func TestHandlerTimeout(t *testing.T) {
 	if res.StatusCode != http.StatusServiceUnavailable {
 		t.Fatalf("expected status code %d, got %d\n", http.StatusServiceUnavailable, res.StatusCode)
 	}
+
+	if res.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("expected Content-Type %s, got %s\n", "application/json", res.Header.Get("Content-Type"))
+	}
+
+	var body timeoutError
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != http.StatusServiceUnavailable || body.Timeout != HandlerTimeout.String() {
+		t.Fatalf("unexpected response body: %+v\n", body)
+	}
+}
+
+func TestHandlerOverrun(t *testing.T) {
+	res, err := http.Get("http://localhost:8080/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	// the slow handler ignores the context and finishes later
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, overrun := range srv.Overruns() {
+			if overrun.Route == "GET /slow" && overrun.Elapsed > HandlerTimeout {
+				return
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	t.Fatalf("expected the slow handler overrun to be recorded, got %v\n", srv.Overruns())
+}
+
+func TestHandlerNoOverrunOnCancellation(t *testing.T) {
+	overruns := &Overruns{}
+
+	// the handler stops as soon as its context is canceled
+	handler := TimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}), 100*time.Millisecond, overruns)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status code %d, got %d\n", http.StatusServiceUnavailable, rr.Code)
+	}
+
+	// leave the grace period expire, in case the handler were still running
+	time.Sleep(2 * OverrunGrace)
+
+	if list := overruns.List(); len(list) != 0 {
+		t.Fatalf("expected no overruns, got %v\n", list)
+	}
+}
+
+func TestHandlerTimeoutStreaming(t *testing.T) {
+	start := time.Now()
+
+	res, err := http.Get("http://localhost:8080/streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d\n", http.StatusOK, res.StatusCode)
+	}
+
+	// the response is not buffered: the first line arrives before the deadline
+	if _, err := bufio.NewReader(res.Body).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= HandlerTimeout {
+		t.Fatalf("expected the first line before %v, got it after %v\n", HandlerTimeout, elapsed)
+	}
+
+	// the stream ends when the handler context is canceled
+	if _, err := ioutil.ReadAll(res.Body); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandlerTimeoutCallerDeadline(t *testing.T) {
+	handler := TimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}), time.Second, nil)
+
+	// the caller deadline expires before the handler one
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected status code %d, got %d\n", http.StatusGatewayTimeout, rr.Code)
+	}
+}
+
+func TestHandlerTimeoutHeaders(t *testing.T) {
+	handler := TimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "test")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, "created")
+	}), time.Second, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d\n", http.StatusCreated, rr.Code)
+	}
+	if rr.Header().Get("X-Test") != "test" {
+		t.Fatalf("expected the handler headers, got %v\n", rr.Header())
+	}
+	if rr.Body.String() != "created" {
+		t.Fatalf("expected body %q, got %q\n", "created", rr.Body.String())
+	}
 }
 
 func TestGetRequest(t *testing.T) {
@@ -105,3 +250,229 @@ func TestGetRequest(t *testing.T) {
 		t.Fatalf("expected status code %d, got %d\n", http.StatusOK, res.StatusCode)
 	}
 }
+
+// waitCounter polls counter until it reaches n
+func waitCounter(t *testing.T, counter func() uint64, n uint64) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for counter() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected counter to reach %d, got %d\n", n, counter())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestMinReadRate(t *testing.T) {
+	slowReads := srv.RateCounters().SlowReads()
+
+	conn, err := net.Dial("tcp", "localhost:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// announce a 1 MiB body, then trickle it
+	fmt.Fprintf(conn, "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\n\r\n", 1024*1024)
+
+	closed := false
+	for i := 0; i < 50; i++ {
+		if _, err := conn.Write([]byte("x")); err != nil {
+			closed = true
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if !closed {
+		// the server may have closed the connection without the writes failing yet
+		if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Fatal("expected the slow client connection to be closed")
+		}
+	}
+
+	waitCounter(t, srv.RateCounters().SlowReads, slowReads+1)
+}
+
+// pacedReader sends its content in chunks, one every interval
+type pacedReader struct {
+	chunks   int
+	size     int
+	interval time.Duration
+}
+
+func (pr *pacedReader) Read(p []byte) (int, error) {
+	if pr.chunks == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(pr.interval)
+	pr.chunks--
+
+	n := pr.size
+	if n > len(p) {
+		n = len(p)
+	}
+	for i := 0; i < n; i++ {
+		p[i] = 'x'
+	}
+	return n, nil
+}
+
+func TestMinReadRateSlowLink(t *testing.T) {
+	// above the minimum rate, but lasting longer than the server ReadTimeout
+	body := &pacedReader{chunks: 8, size: 1024, interval: 750 * time.Millisecond}
+
+	res, err := http.Post("http://localhost:8080/upload", "application/octet-stream", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != "8192" {
+		t.Fatalf("expected the whole body to be received, got %q\n", string(buf))
+	}
+}
+
+func TestMinWriteRate(t *testing.T) {
+	slowWrites := srv.RateCounters().SlowWrites()
+
+	conn, err := net.Dial("tcp", "localhost:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// request the download, but never read the response
+	fmt.Fprint(conn, "GET /download HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	waitCounter(t, srv.RateCounters().SlowWrites, slowWrites+1)
+}
+
+func TestMinWriteRatePausingHandler(t *testing.T) {
+	counters := &RateCounters{}
+
+	ts := httptest.NewUnstartedServer(MinRateHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := w.(http.Flusher)
+
+		for i := 0; i < 3; i++ {
+			if i > 0 {
+				// the pauses of the handler don't count against the client
+				time.Sleep(RateGrace + RateGrace/2)
+			}
+
+			fmt.Fprintln(w, "Hello, paced world!")
+			f.Flush()
+		}
+	}), MinRate{Write: MinWriteRate, Grace: RateGrace}, counters))
+	ts.Config.ConnContext = withConn
+	ts.Start()
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Count(string(body), "\n"); n != 3 {
+		t.Fatalf("expected 3 lines, got %d\n", n)
+	}
+	if n := counters.SlowWrites(); n != 0 {
+		t.Fatalf("expected no slow writes, got %d\n", n)
+	}
+}
+
+func TestRateDeadline(t *testing.T) {
+	now := time.Now()
+
+	testCases := []struct {
+		name     string
+		n        int64
+		rate     int64
+		expected time.Time
+	}{
+		{
+			name:     "small transfer",
+			n:        3 * 1024,
+			rate:     1024,
+			expected: now.Add(RateGrace + 3*time.Second),
+		},
+		{
+			name:     "large transfer",
+			n:        1 << 40,
+			rate:     1,
+			expected: now.Add(RateGrace + maxAllowed),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			deadline := rateDeadline(now, 0, RateGrace, tc.n, tc.rate)
+			if !deadline.Equal(tc.expected) {
+				t.Fatalf("expected deadline %v, got %v\n", tc.expected, deadline)
+			}
+		})
+	}
+}
+
+// deadlineConn records the deadlines set on the connection
+type deadlineConn struct {
+	net.Conn
+	reads  []time.Time
+	writes []time.Time
+}
+
+func (dc *deadlineConn) SetReadDeadline(t time.Time) error {
+	dc.reads = append(dc.reads, t)
+	return nil
+}
+
+func (dc *deadlineConn) SetWriteDeadline(t time.Time) error {
+	dc.writes = append(dc.writes, t)
+	return nil
+}
+
+func TestMinRateServerDeadlines(t *testing.T) {
+	conn := &deadlineConn{}
+
+	handler := MinRateHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Hello, world!")
+	}), MinRate{Write: MinWriteRate, Grace: RateGrace}, &RateCounters{})
+
+	ctx := withConn(context.Background(), conn)
+	ctx = context.WithValue(ctx, http.ServerContextKey, &http.Server{
+		ReadTimeout:  SrvReadTimeout,
+		WriteTimeout: SrvWriteTimeout,
+	})
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+
+	start := time.Now()
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// without a minimum read rate, the read deadline is left to the server
+	if len(conn.reads) != 0 {
+		t.Fatalf("expected the read deadline to be untouched, got %v\n", conn.reads)
+	}
+
+	// the last chunk is sent within the server WriteTimeout
+	if len(conn.writes) == 0 {
+		t.Fatal("expected the write deadline to be set")
+	}
+	last := conn.writes[len(conn.writes)-1]
+	if last.Before(start.Add(SrvWriteTimeout)) || last.After(time.Now().Add(SrvWriteTimeout)) {
+		t.Fatalf("expected the write deadline to be put back to the server one, got %v\n", last)
+	}
+}
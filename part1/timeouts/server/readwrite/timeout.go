@@ -0,0 +1,300 @@
+package readwrite
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxOverruns is the maximum number of overruns kept in memory
+	maxOverruns int = 100
+
+	// OverrunGrace is the time a handler has to return once its context is canceled,
+	// before being recorded as an overrun
+	OverrunGrace time.Duration = 100 * time.Millisecond
+)
+
+// states of a handler run by TimeoutHandler
+const (
+	handlerRunning int32 = iota
+	handlerReturned
+	handlerOverran
+)
+
+// Overrun holds the information about a handler that kept running after its deadline
+type Overrun struct {
+	Route    string
+	Timeout  time.Duration
+	Elapsed  time.Duration
+	Finished time.Time
+}
+
+// Overruns records the handlers that overran their deadline, keeping the most recent ones
+// It is safe to use concurrently
+type Overruns struct {
+	mu   sync.Mutex
+	list []Overrun
+}
+
+// Record adds an overrun, dropping the oldest one if needed
+func (o *Overruns) Record(overrun Overrun) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.list) == maxOverruns {
+		o.list = append(o.list[:0], o.list[1:]...)
+	}
+	o.list = append(o.list, overrun)
+}
+
+// List returns the recorded overruns, oldest first
+func (o *Overruns) List() []Overrun {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	list := make([]Overrun, len(o.list))
+	copy(list, o.list)
+
+	return list
+}
+
+// timeoutError is the body of the response sent when the handler times out
+type timeoutError struct {
+	Code    int    `json:"code"`
+	Text    string `json:"text"`
+	Timeout string `json:"timeout"`
+}
+
+// TimeoutHandler returns a http.Handler that runs next with a deadline of d
+//
+// Unlike http.TimeoutHandler, the response is not buffered: when the deadline
+// expires, the request context is canceled and, if next hasn't written
+// anything yet, the client gets a JSON 503 Service Unavailable (or 504 Gateway
+// Timeout, if the deadline was set by the caller). Flush and Hijack are passed
+// through to the underlying response writer, so streaming handlers keep working.
+// Once the first byte is written, the response belongs to next, that is expected
+// to stop writing when the context is canceled.
+//
+// If overruns is not nil, each handler still running OverrunGrace after
+// its context is canceled is recorded in it when it finally returns:
+// a handler stopping promptly on cancellation is not an overrun.
+func TimeoutHandler(next http.Handler, d time.Duration, overruns *Overruns) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+
+		tw := &timeoutWriter{
+			w: w,
+			h: make(http.Header),
+		}
+
+		start := time.Now()
+		done := make(chan struct{})
+		panicChan := make(chan interface{}, 1)
+
+		state := handlerRunning
+		go func() {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+			}
+
+			grace := time.NewTimer(OverrunGrace)
+			defer grace.Stop()
+
+			select {
+			case <-done:
+			case <-grace.C:
+				atomic.CompareAndSwapInt32(&state, handlerRunning, handlerOverran)
+			}
+		}()
+
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicChan <- p
+				}
+			}()
+
+			next.ServeHTTP(tw, r.WithContext(ctx))
+
+			if !atomic.CompareAndSwapInt32(&state, handlerRunning, handlerReturned) {
+				elapsed := time.Since(start)
+				route := r.Method + " " + r.URL.Path
+				log.Printf("handler %s overran its deadline of %v, returning after %v\n", route, d, elapsed)
+
+				if overruns != nil {
+					overruns.Record(Overrun{
+						Route:    route,
+						Timeout:  d,
+						Elapsed:  elapsed,
+						Finished: time.Now(),
+					})
+				}
+			}
+
+			close(done)
+		}()
+
+		select {
+		case p := <-panicChan:
+			panic(p)
+		case <-done:
+			return
+		case <-ctx.Done():
+		}
+
+		tw.mu.Lock()
+		if tw.wroteHeader {
+			// the response is already on its way, wait for next to stop writing
+			tw.mu.Unlock()
+
+			select {
+			case p := <-panicChan:
+				panic(p)
+			case <-done:
+			}
+			return
+		}
+		defer tw.mu.Unlock()
+
+		// from now on, next can't write to the response anymore
+		tw.timedOut = true
+
+		switch err := r.Context().Err(); {
+		case errors.Is(err, context.Canceled):
+			// the client went away, there's nobody to respond to
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			// the deadline set by the caller expired first
+			writeTimeoutError(w, http.StatusGatewayTimeout, d)
+		default:
+			writeTimeoutError(w, http.StatusServiceUnavailable, d)
+		}
+	})
+}
+
+func writeTimeoutError(w http.ResponseWriter, code int, d time.Duration) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(timeoutError{
+		Code:    code,
+		Text:    http.StatusText(code),
+		Timeout: d.String(),
+	}); err != nil {
+		log.Println(err)
+	}
+}
+
+// timeoutWriter writes through the underlying response writer
+// until the handler times out
+type timeoutWriter struct {
+	w http.ResponseWriter
+	h http.Header
+
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+}
+
+// Header satisfies the http.ResponseWriter interface
+// The headers are copied to the underlying response writer on the first write,
+// so that the handler can't race with the timeout response
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.h
+}
+
+// Write satisfies the http.ResponseWriter interface
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	tw.writeHeaderLocked(http.StatusOK)
+
+	return tw.w.Write(p)
+}
+
+// WriteHeader satisfies the http.ResponseWriter interface
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return
+	}
+
+	tw.writeHeaderLocked(code)
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(code int) {
+	if tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+
+	dst := tw.w.Header()
+	for k, v := range tw.h {
+		dst[k] = v
+	}
+
+	tw.w.WriteHeader(code)
+}
+
+// Flush satisfies the http.Flusher interface
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return
+	}
+
+	f, ok := tw.w.(http.Flusher)
+	if !ok {
+		return
+	}
+
+	tw.writeHeaderLocked(http.StatusOK)
+	f.Flush()
+}
+
+// Hijack satisfies the http.Hijacker interface
+// The connection can be hijacked only before writing anything
+func (tw *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	if tw.wroteHeader {
+		return nil, nil, errors.New("readwrite: hijack after the response has been written")
+	}
+
+	hj, ok := tw.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("readwrite: hijack not supported")
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err == nil {
+		// the timeout can't respond on a hijacked connection
+		tw.wroteHeader = true
+	}
+
+	return conn, rw, err
+}
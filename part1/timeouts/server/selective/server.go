@@ -6,12 +6,12 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"sync"
 	"time"
 )
 
 // Timeouts holds all the timeouts to be set in the Server
 type Timeouts struct {
+	Read  time.Duration
 	Write time.Duration
 }
 
@@ -44,10 +44,120 @@ func NewDeadlineController(c net.Conn, s *http.Server) *DeadlineController {
 	}
 }
 
+// FromContext returns the DeadlineController stored in ctx, if any
+func FromContext(ctx context.Context) (*DeadlineController, bool) {
+	dc, ok := ctx.Value(DeadlineControllerKey).(*DeadlineController)
+	return dc, ok
+}
+
 // ExtendWriteDeadline extends the write deadline on the underlying
-// stream-oriented connection
+// stream-oriented connection by the server WriteTimeout
+// It does nothing if the server has no WriteTimeout
 func (dc *DeadlineController) ExtendWriteDeadline() error {
-	return dc.c.SetWriteDeadline(time.Now().Add(dc.s.WriteTimeout))
+	if dc.s == nil || dc.s.WriteTimeout == 0 {
+		return nil
+	}
+
+	return dc.ExtendWriteDeadlineBy(dc.s.WriteTimeout)
+}
+
+// ExtendWriteDeadlineBy extends the write deadline on the underlying
+// stream-oriented connection by d
+func (dc *DeadlineController) ExtendWriteDeadlineBy(d time.Duration) error {
+	return dc.c.SetWriteDeadline(time.Now().Add(d))
+}
+
+// SetWriteDeadline sets the write deadline on the underlying
+// stream-oriented connection
+// A zero value for t means writes will not time out
+func (dc *DeadlineController) SetWriteDeadline(t time.Time) error {
+	return dc.c.SetWriteDeadline(t)
+}
+
+// ExtendReadDeadline extends the read deadline on the underlying
+// stream-oriented connection by the server ReadTimeout
+// It does nothing if the server has no ReadTimeout
+func (dc *DeadlineController) ExtendReadDeadline() error {
+	if dc.s == nil || dc.s.ReadTimeout == 0 {
+		return nil
+	}
+
+	return dc.ExtendReadDeadlineBy(dc.s.ReadTimeout)
+}
+
+// ExtendReadDeadlineBy extends the read deadline on the underlying
+// stream-oriented connection by d
+func (dc *DeadlineController) ExtendReadDeadlineBy(d time.Duration) error {
+	return dc.c.SetReadDeadline(time.Now().Add(d))
+}
+
+// SetReadDeadline sets the read deadline on the underlying
+// stream-oriented connection
+// A zero value for t means reads will not time out
+func (dc *DeadlineController) SetReadDeadline(t time.Time) error {
+	return dc.c.SetReadDeadline(t)
+}
+
+// ExtendingWriter is a http.ResponseWriter that extends the write deadline
+// each time the response is flushed
+type ExtendingWriter struct {
+	http.ResponseWriter
+	dc *DeadlineController
+	d  time.Duration
+}
+
+// NewExtendingWriter wraps w to extend the write deadline through dc
+// by the server WriteTimeout on each Flush
+func NewExtendingWriter(w http.ResponseWriter, dc *DeadlineController) *ExtendingWriter {
+	return &ExtendingWriter{
+		ResponseWriter: w,
+		dc:             dc,
+	}
+}
+
+// NewExtendingWriterBy wraps w to extend the write deadline through dc by d on each Flush
+func NewExtendingWriterBy(w http.ResponseWriter, dc *DeadlineController, d time.Duration) *ExtendingWriter {
+	return &ExtendingWriter{
+		ResponseWriter: w,
+		dc:             dc,
+		d:              d,
+	}
+}
+
+// Flush satisfies the http.Flusher interface
+// The write deadline is extended right before flushing, so that each flush
+// has a whole timeout to complete, even if it blocks on a slow client
+func (ew *ExtendingWriter) Flush() {
+	f, ok := ew.ResponseWriter.(http.Flusher)
+	if !ok {
+		return
+	}
+
+	extend := ew.dc.ExtendWriteDeadline
+	if ew.d > 0 {
+		extend = func() error { return ew.dc.ExtendWriteDeadlineBy(ew.d) }
+	}
+
+	if err := extend(); err != nil {
+		log.Println(err)
+	}
+
+	f.Flush()
+}
+
+// ExtendOnFlush wraps next to make its response writer extend the write
+// deadline on each Flush: streaming handlers keep working under
+// the server WriteTimeout as long as they flush often enough
+func ExtendOnFlush(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dc, ok := FromContext(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(NewExtendingWriter(w, dc), r)
+	})
 }
 
 // NewServer returns a HTTP server listening on addr
@@ -61,6 +171,7 @@ func NewServer(addr string, timeouts Timeouts) *Server {
 	srv := &Server{
 		http.Server{
 			Addr:         addr,
+			ReadTimeout:  timeouts.Read,
 			WriteTimeout: timeouts.Write,
 			Handler:      mux,
 		},
@@ -89,21 +200,12 @@ func (srv *Server) Run() <-chan error {
 	return errs
 }
 
-// Shutdown makes the server stop listening and refuse further connections
-// It takes a context to limit the shutdown duration and a wait group to signal
-// the caller when the shutdown process has finished
-func (srv *Server) Shutdown(ctx context.Context, wg *sync.WaitGroup) error {
-	defer wg.Done()
-	return srv.Server.Shutdown(ctx)
-}
-
 func handler(w http.ResponseWriter, r *http.Request) {
 	count := 0
 	for {
 		time.Sleep(time.Second)
 
-		value := r.Context().Value(DeadlineControllerKey)
-		dlController, ok := value.(*DeadlineController)
+		dlController, ok := FromContext(r.Context())
 		if !ok {
 			log.Println("casting errror")
 			return
@@ -0,0 +1,165 @@
+package sse
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server-Sent Events: https://html.spec.whatwg.org/multipage/server-sent-events.html
+
+// LastEventIDHeader is the request header sent by the clients to resume a stream
+const LastEventIDHeader string = "Last-Event-ID"
+
+// ErrStreamingUnsupported is returned when the response writer can't be flushed
+var ErrStreamingUnsupported = errors.New("sse: streaming unsupported")
+
+// Event is a single Server-Sent Event
+type Event struct {
+	// ID sets the last event ID of the client, used to resume the stream
+	ID string
+	// Event is the event type (the client default is "message")
+	Event string
+	// Data is the event payload, it may span multiple lines
+	Data string
+	// Retry is the reconnection time the client should use
+	Retry time.Duration
+}
+
+// singleLine removes the line breaks from a field value,
+// since they would terminate the field
+func singleLine(s string) string {
+	return strings.NewReplacer("\r\n", "", "\r", "", "\n", "").Replace(s)
+}
+
+// lines splits s on any of the line breaks allowed by the spec
+func lines(s string) []string {
+	s = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(s)
+	return strings.Split(s, "\n")
+}
+
+// encode returns the wire format of the event
+func (ev Event) encode() []byte {
+	var buf bytes.Buffer
+
+	if ev.ID != "" {
+		fmt.Fprintf(&buf, "id: %s\n", singleLine(ev.ID))
+	}
+	if ev.Event != "" {
+		fmt.Fprintf(&buf, "event: %s\n", singleLine(ev.Event))
+	}
+	if ev.Retry > 0 {
+		fmt.Fprintf(&buf, "retry: %d\n", ev.Retry.Milliseconds())
+	}
+	// each line of the payload needs its own data field
+	for _, line := range lines(ev.Data) {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	// a blank line dispatches the event
+	buf.WriteString("\n")
+
+	return buf.Bytes()
+}
+
+// Writer writes Server-Sent Events to a HTTP response
+// It is safe to use concurrently, e.g. to send heartbeats while sending events
+type Writer struct {
+	mu sync.Mutex
+	w  http.ResponseWriter
+	f  http.Flusher
+}
+
+// NewWriter sets the event stream headers and returns a Writer sending events to w
+// It returns ErrStreamingUnsupported if w can't be flushed
+func NewWriter(w http.ResponseWriter) (*Writer, error) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		return nil, ErrStreamingUnsupported
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// send the headers right away, the first event may take a while
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	return &Writer{
+		w: w,
+		f: f,
+	}, nil
+}
+
+func (sw *Writer) write(p []byte) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	if _, err := sw.w.Write(p); err != nil {
+		return err
+	}
+	sw.f.Flush()
+
+	return nil
+}
+
+// Send writes the event to the stream and flushes it
+func (sw *Writer) Send(ev Event) error {
+	return sw.write(ev.encode())
+}
+
+// Comment writes a comment to the stream, ignored by the clients
+func (sw *Writer) Comment(text string) error {
+	var buf bytes.Buffer
+
+	for _, line := range lines(text) {
+		fmt.Fprintf(&buf, ": %s\n", line)
+	}
+	buf.WriteString("\n")
+
+	return sw.write(buf.Bytes())
+}
+
+// Heartbeat sends a comment every interval, to keep the connection alive
+// when no events are sent for a while
+// The returned function stops the heartbeat and must be called
+// before the handler returns
+func (sw *Writer) Heartbeat(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := sw.Comment("heartbeat"); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+// LastEventID returns the ID of the last event received by the client, if any
+func LastEventID(r *http.Request) string {
+	return r.Header.Get(LastEventIDHeader)
+}
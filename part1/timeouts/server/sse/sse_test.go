@@ -0,0 +1,124 @@
+package sse
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSend(t *testing.T) {
+	testCases := []struct {
+		name     string
+		event    Event
+		expected string
+	}{
+		{
+			name:     "data only",
+			event:    Event{Data: "hello"},
+			expected: "data: hello\n\n",
+		},
+		{
+			name: "all fields",
+			event: Event{
+				ID:    "42",
+				Event: "news",
+				Data:  "hello",
+				Retry: 3 * time.Second,
+			},
+			expected: "id: 42\nevent: news\nretry: 3000\ndata: hello\n\n",
+		},
+		{
+			name:     "multi-line data",
+			event:    Event{Data: "first\nsecond\r\nthird\rfourth"},
+			expected: "data: first\ndata: second\ndata: third\ndata: fourth\n\n",
+		},
+		{
+			name:     "line breaks in single line fields",
+			event:    Event{ID: "4\n2", Event: "ne\r\nws", Data: "hello"},
+			expected: "id: 42\nevent: news\ndata: hello\n\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+
+			sw, err := NewWriter(rr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := sw.Send(tc.event); err != nil {
+				t.Fatal(err)
+			}
+
+			if rr.Header().Get("Content-Type") != "text/event-stream" {
+				t.Fatalf("expected Content-Type %s, got %s\n", "text/event-stream", rr.Header().Get("Content-Type"))
+			}
+
+			if rr.Body.String() != tc.expected {
+				t.Fatalf("expected %q, got %q\n", tc.expected, rr.Body.String())
+			}
+		})
+	}
+}
+
+type noFlusher struct {
+	http.ResponseWriter
+}
+
+func TestStreamingUnsupported(t *testing.T) {
+	_, err := NewWriter(noFlusher{httptest.NewRecorder()})
+	if err != ErrStreamingUnsupported {
+		t.Fatalf("expected %v, got: %v\n", ErrStreamingUnsupported, err)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	sw, err := NewWriter(rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := sw.Heartbeat(10 * time.Millisecond)
+	time.Sleep(55 * time.Millisecond)
+	stop()
+
+	if n := strings.Count(rr.Body.String(), ": heartbeat\n\n"); n < 3 {
+		t.Fatalf("expected at least 3 heartbeats, got %d\n", n)
+	}
+}
+
+func TestComment(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	sw, err := NewWriter(rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sw.Comment("first\nsecond"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ": first\n: second\n\n"
+	if rr.Body.String() != expected {
+		t.Fatalf("expected %q, got %q\n", expected, rr.Body.String())
+	}
+}
+
+func TestLastEventID(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if id := LastEventID(req); id != "" {
+		t.Fatalf("expected no last event ID, got %q\n", id)
+	}
+
+	req.Header.Set(LastEventIDHeader, "42")
+	if id := LastEventID(req); id != "42" {
+		t.Fatalf("expected last event ID %q, got %q\n", "42", id)
+	}
+}
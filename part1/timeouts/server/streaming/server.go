@@ -2,11 +2,14 @@ package streaming
 
 import (
 	"context"
-	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"sync"
+	"strconv"
 	"time"
+
+	"github.com/Pippolo84/go-services-patterns/part1/timeouts/server/selective"
+	"github.com/Pippolo84/go-services-patterns/part1/timeouts/server/sse"
 )
 
 // Timeouts holds all the timeouts to be set in the Server
@@ -25,9 +28,11 @@ type Server struct {
 func NewServer(addr string, timeouts Timeouts) *Server {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/streaming", streamingHandler)
+	// each flush extends the write deadline, so the stream
+	// can last longer than the WriteTimeout
+	mux.Handle("/streaming", selective.ExtendOnFlush(http.HandlerFunc(streamingHandler)))
 
-	return &Server{
+	srv := &Server{
 		http.Server{
 			Addr: addr,
 
@@ -36,6 +41,13 @@ func NewServer(addr string, timeouts Timeouts) *Server {
 			Handler: mux,
 		},
 	}
+
+	srv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		dc := selective.NewDeadlineController(c, &srv.Server)
+		return context.WithValue(ctx, selective.DeadlineControllerKey, dc)
+	}
+
+	return srv
 }
 
 // Run starts the server, making it listening on specified address
@@ -53,37 +65,28 @@ func (srv *Server) Run() <-chan error {
 	return errs
 }
 
-// Shutdown makes the server stop listening and refuse further connections
-// It takes a context to limit the shutdown duration and a wait group to signal
-// the caller when the shutdown process has finished
-func (srv *Server) Shutdown(ctx context.Context, wg *sync.WaitGroup) error {
-	defer wg.Done()
-	return srv.Server.Shutdown(ctx)
-}
-
 func streamingHandler(w http.ResponseWriter, r *http.Request) {
 	defer log.Println("streaming finished!")
 
 	// make sure the connection supports the streaming
-	f, ok := w.(http.Flusher)
-	if !ok {
+	sw, err := sse.NewWriter(w)
+	if err != nil {
 		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
 		return
 	}
 
-	// set the headers or event streaming
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Transfer-Encoding", "chunked")
-
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 
 	count := 0
 	for range ticker.C {
-		fmt.Fprintln(w, "Hello, streaming world!")
-		f.Flush()
+		if err := sw.Send(sse.Event{
+			ID:   strconv.Itoa(count),
+			Data: "Hello, streaming world!",
+		}); err != nil {
+			log.Println(err)
+			return
+		}
 
 		count++
 		if count > 5 {
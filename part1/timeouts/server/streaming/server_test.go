@@ -5,11 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"strings"
-	"sync"
 	"testing"
 	"time"
 )
@@ -48,7 +48,7 @@ func startServer(t *testing.T, addr string, timeouts Timeouts) (*Server, <-chan
 	return srv, errs
 }
 
-func TestWriteTimeout(t *testing.T) {
+func TestWriteTimeoutExtendedOnFlush(t *testing.T) {
 	srv, srvErrs := startServer(t, ":8080", Timeouts{
 		Write: 2 * time.Second,
 	})
@@ -72,27 +72,20 @@ func TestWriteTimeout(t *testing.T) {
 		t.Fatalf("expected http status code %d, got %d\n", http.StatusOK, res.StatusCode)
 	}
 
-	buf := make([]byte, 512)
-	for {
-		_, err := res.Body.Read(buf)
-		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
-			t.Fatal(err)
-		}
-
-		if errors.Is(err, io.ErrUnexpectedEOF) {
-			break
-		}
+	// the stream lasts longer than WriteTimeout, but each flush extends it
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(1)
+	if n := strings.Count(string(body), "data: "); n != 6 {
+		t.Fatalf("expected 6 events, got %d\n", n)
+	}
 
-	if err := srv.Shutdown(context.Background(), &wg); err != nil {
+	if err := srv.Shutdown(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 
-	wg.Wait()
-
 	// srvErrs should have been closed without errors
 	for err := range srvErrs {
 		if errors.Is(err, http.ErrServerClosed) {
@@ -137,15 +130,10 @@ func TestEOF(t *testing.T) {
 		}
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-
-	if err := srv.Shutdown(context.Background(), &wg); err != nil {
+	if err := srv.Shutdown(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 
-	wg.Wait()
-
 	// srvErrs should have been closed without errors
 	for err := range srvErrs {
 		if errors.Is(err, http.ErrServerClosed) {
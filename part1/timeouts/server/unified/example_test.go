@@ -0,0 +1,28 @@
+package unified
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// Each route declares its own timeouts policy on top of the server defaults:
+// the "/" endpoint must respond within 1 second,
+// the "/streaming" endpoint extends the write deadline on each flush
+// and the "/upload" endpoint has 1 minute to receive the request body
+func ExampleNewServer() {
+	srv := NewServer(":8080", Timeouts{
+		ReadHeader: time.Second,
+		Read:       2 * time.Second,
+		Write:      2 * time.Second,
+		Idle:       time.Minute,
+	}, []Route{
+		{Pattern: "/", Handler: http.HandlerFunc(handler), Policy: APIPolicy(time.Second, 2*time.Second)},
+		{Pattern: "/streaming", Handler: http.HandlerFunc(streamingHandler), Policy: StreamingPolicy(2 * time.Second)},
+		{Pattern: "/upload", Handler: http.HandlerFunc(uploadHandler), Policy: UploadPolicy(time.Minute)},
+	})
+
+	for err := range srv.Run() {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,192 @@
+package unified
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Pippolo84/go-services-patterns/part1/timeouts/server/readwrite"
+	"github.com/Pippolo84/go-services-patterns/part1/timeouts/server/selective"
+)
+
+// Server Timeout: https://blog.cloudflare.com/the-complete-guide-to-golang-net-http-timeouts/
+
+// Timeouts holds all the timeouts to be set in the Server
+// They are the defaults for all the routes: each route can override them with its own Policy
+type Timeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+// Policy describes how the timeouts are enforced on a route
+type Policy struct {
+	// Handler is the total deadline for the handler to respond
+	// When it expires, the request context is canceled and, if the handler
+	// hasn't written anything yet, the client gets a 503
+	Handler time.Duration
+
+	// Write overrides the server write timeout for the route
+	Write time.Duration
+
+	// Read overrides the server read timeout for the route, e.g. to give
+	// more time to upload a large request body
+	Read time.Duration
+
+	// Streaming removes the write deadline for the route
+	Streaming bool
+	// Heartbeat, for a streaming route, is the write deadline
+	// extended on each Flush of the response
+	Heartbeat time.Duration
+}
+
+// APIPolicy returns the policy for an API route that must respond within handler
+// and whose response must be written within write
+func APIPolicy(handler, write time.Duration) Policy {
+	return Policy{
+		Handler: handler,
+		Write:   write,
+	}
+}
+
+// StreamingPolicy returns the policy for a streaming route: the write deadline
+// is extended by heartbeat each time the handler flushes the response
+// If heartbeat is zero, the route has no write deadline at all
+func StreamingPolicy(heartbeat time.Duration) Policy {
+	return Policy{
+		Streaming: true,
+		Heartbeat: heartbeat,
+	}
+}
+
+// UploadPolicy returns the policy for an upload route, that has read
+// to receive the whole request body
+func UploadPolicy(read time.Duration) Policy {
+	return Policy{
+		Read: read,
+	}
+}
+
+// Route holds a handler with its timeouts policy
+type Route struct {
+	Pattern string
+	Handler http.Handler
+	Policy  Policy
+}
+
+// Server is a HTTP server with configurable timeouts
+// that enforces a different timeouts policy for each route
+type Server struct {
+	http.Server
+
+	overruns *readwrite.Overruns
+}
+
+// NewServer returns a HTTP server listening on addr,
+// configured with the specified timeouts and serving the routes
+func NewServer(addr string, timeouts Timeouts, routes []Route) *Server {
+	mux := http.NewServeMux()
+	overruns := &readwrite.Overruns{}
+
+	for _, route := range routes {
+		mux.Handle(route.Pattern, enforce(route.Policy, timeouts.Write, overruns, route.Handler))
+	}
+
+	srv := &Server{
+		Server: http.Server{
+			Addr: addr,
+
+			ReadHeaderTimeout: timeouts.ReadHeader,
+			ReadTimeout:       timeouts.Read,
+			WriteTimeout:      timeouts.Write,
+			IdleTimeout:       timeouts.Idle,
+
+			Handler: mux,
+		},
+		overruns: overruns,
+	}
+
+	// the policies need the underlying connection to change its deadlines
+	srv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		dc := selective.NewDeadlineController(c, &srv.Server)
+		return context.WithValue(ctx, selective.DeadlineControllerKey, dc)
+	}
+
+	return srv
+}
+
+// Overruns returns the handlers that kept running after their deadline
+func (srv *Server) Overruns() []readwrite.Overrun {
+	return srv.overruns.List()
+}
+
+// Run starts the server, making it listening on specified address
+// it returns a channel where all errors are relayed
+func (srv *Server) Run() <-chan error {
+	errs := make(chan error)
+
+	go func() {
+		defer close(errs)
+		if err := srv.ListenAndServe(); err != nil {
+			errs <- err
+		}
+	}()
+
+	return errs
+}
+
+// enforce wraps next to apply the policy to each request
+// write is the server write timeout, used when the policy doesn't override it
+// The handlers overrunning the policy deadline are recorded in overruns
+func enforce(policy Policy, write time.Duration, overruns *readwrite.Overruns, next http.Handler) http.Handler {
+	if policy.Handler > 0 {
+		next = readwrite.TimeoutHandler(next, policy.Handler, overruns)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dc, ok := selective.FromContext(r.Context())
+		if !ok {
+			// no connection to control (e.g. in tests using a recorder)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		writeTimeout := policy.Write
+
+		// the server has already set the deadlines using its own timeouts,
+		// right after reading the request headers
+		if policy.Read > 0 {
+			if err := dc.SetReadDeadline(now.Add(policy.Read)); err != nil {
+				log.Println(err)
+			}
+
+			// the response is usually written after reading the whole body,
+			// so the write deadline is shifted by the extended read time
+			if writeTimeout == 0 && write > 0 {
+				writeTimeout = policy.Read + write
+			}
+		}
+
+		switch {
+		case policy.Streaming && policy.Heartbeat > 0:
+			if err := dc.SetWriteDeadline(now.Add(policy.Heartbeat)); err != nil {
+				log.Println(err)
+			}
+			w = selective.NewExtendingWriterBy(w, dc, policy.Heartbeat)
+		case policy.Streaming:
+			if err := dc.SetWriteDeadline(time.Time{}); err != nil {
+				log.Println(err)
+			}
+		case writeTimeout > 0:
+			if err := dc.SetWriteDeadline(now.Add(writeTimeout)); err != nil {
+				log.Println(err)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
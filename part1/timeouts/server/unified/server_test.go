@@ -0,0 +1,197 @@
+package unified
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// go test -v -timeout=30s .
+
+const (
+	SrvReadTimeout  time.Duration = time.Second
+	SrvWriteTimeout time.Duration = time.Second
+)
+
+func TestMain(m *testing.M) {
+	// create and run the server under test
+	srv := NewServer(":8090", Timeouts{
+		ReadHeader: SrvReadTimeout,
+		Read:       SrvReadTimeout,
+		Write:      SrvWriteTimeout,
+	}, []Route{
+		{
+			Pattern: "/api",
+			Handler: http.HandlerFunc(handler),
+			Policy:  APIPolicy(time.Second, time.Second),
+		},
+		{
+			Pattern: "/slow",
+			Handler: http.HandlerFunc(slowHandler),
+			Policy:  APIPolicy(time.Second, 5*time.Second),
+		},
+		{
+			Pattern: "/default",
+			Handler: http.HandlerFunc(slowHandler),
+		},
+		{
+			Pattern: "/streaming",
+			Handler: http.HandlerFunc(streamingHandler),
+			Policy:  StreamingPolicy(time.Second),
+		},
+		{
+			Pattern: "/upload",
+			Handler: http.HandlerFunc(uploadHandler),
+			Policy:  UploadPolicy(5 * time.Second),
+		},
+	})
+
+	go func() {
+		for err := range srv.Run() {
+			log.Fatal(err)
+		}
+	}()
+
+	// wait for the server to listen on port 8090
+	for {
+		_, err := net.Dial("tcp", "127.0.0.1:8090")
+		if err == nil {
+			break
+		}
+		if strings.Contains(err.Error(), "connection refused") {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		log.Fatal(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+func handler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "Hello, world!")
+}
+
+func slowHandler(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-time.After(2 * time.Second):
+	case <-r.Context().Done():
+		return
+	}
+	fmt.Fprintf(w, "Hello, slow world!")
+}
+
+func streamingHandler(w http.ResponseWriter, r *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+		return
+	}
+
+	// stream for longer than the server write timeout
+	for i := 0; i < 6; i++ {
+		fmt.Fprintln(w, "Hello, streaming world!")
+		f.Flush()
+
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func uploadHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := io.Copy(ioutil.Discard, r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestTimeout)
+		return
+	}
+
+	fmt.Fprintf(w, "%d", n)
+}
+
+func TestAPIRoute(t *testing.T) {
+	res, err := http.Get("http://localhost:8090/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d\n", http.StatusOK, res.StatusCode)
+	}
+}
+
+func TestHandlerDeadline(t *testing.T) {
+	res, err := http.Get("http://localhost:8090/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected status code %d, got %d\n", http.StatusServiceUnavailable, res.StatusCode)
+	}
+}
+
+func TestDefaultWriteTimeout(t *testing.T) {
+	// without a policy, the server write timeout applies
+	_, err := http.Get("http://localhost:8090/default")
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF error, got: %v\n", err)
+	}
+}
+
+func TestStreamingRoute(t *testing.T) {
+	res, err := http.Get("http://localhost:8090/streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("expected the whole stream, got: %v\n", err)
+	}
+
+	if n := strings.Count(string(body), "\n"); n != 6 {
+		t.Fatalf("expected 6 lines, got %d\n", n)
+	}
+}
+
+// slowReader trickles its content, one byte every interval
+type slowReader struct {
+	n        int
+	interval time.Duration
+}
+
+func (sr *slowReader) Read(p []byte) (int, error) {
+	if sr.n == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(sr.interval)
+	sr.n--
+	p[0] = 'x'
+	return 1, nil
+}
+
+func TestUploadRoute(t *testing.T) {
+	// the upload lasts longer than the server read timeout
+	body := &slowReader{n: 10, interval: 200 * time.Millisecond}
+
+	res, err := http.Post("http://localhost:8090/upload", "application/octet-stream", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d\n", http.StatusOK, res.StatusCode)
+	}
+}
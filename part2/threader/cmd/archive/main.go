@@ -13,6 +13,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/Pippolo84/go-services-patterns/part1/graceful-shutdown/shutdown"
 	"github.com/Pippolo84/go-services-patterns/part2/threader/internal/model"
 	"github.com/Pippolo84/go-services-patterns/part2/threader/internal/service"
 	"github.com/Pippolo84/go-services-patterns/part2/threader/internal/validate"
@@ -29,6 +30,10 @@ const (
 	// SvcCooldownTimeout is the maximum cooldown time before forcing the shutdown
 	SvcCooldownTimeout time.Duration = 10 * time.Second
 
+	// SvcWatchdogMargin is the extra time given to the shutdown before
+	// dumping the goroutines and exiting hard
+	SvcWatchdogMargin time.Duration = 5 * time.Second
+
 	// MaxRequestBodySize is the maximum size, in bytes, if a request body
 	MaxRequestBodySize int64 = 1 << 20
 )
@@ -39,7 +44,6 @@ func main() {
 	if err := archive.Init(); err != nil {
 		log.Fatalf("initialization error: %v\n", err)
 	}
-	defer archive.Close()
 
 	errs := archive.Run()
 
@@ -58,15 +62,19 @@ func main() {
 	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), SvcCooldownTimeout)
 	defer cancelShutdown()
 
-	var stopWg sync.WaitGroup
-	stopWg.Add(1)
+	coordinator := shutdown.NewCoordinator()
+	archive.RegisterShutdownHooks(coordinator)
+
+	// if the shutdown hangs, collect some evidence and exit
+	watchdog := shutdown.NewWatchdog(SvcCooldownTimeout+SvcWatchdogMargin, os.Stderr)
+	watchdog.AddDumper("in-flight requests", archive.DumpInFlight)
+	watchdog.Start()
+	defer watchdog.Stop()
 
-	if err := archive.Shutdown(shutdownCtx, &stopWg); err != nil {
+	// cleanup everything, phase by phase
+	if err := coordinator.Shutdown(shutdownCtx); err != nil {
 		log.Println(err)
 	}
-
-	// wait for service to cleanup everything
-	stopWg.Wait()
 }
 
 // Archive represents our specific service
@@ -109,6 +117,17 @@ func (a *Archive) Close() {
 	a.store.Close()
 }
 
+// RegisterShutdownHooks registers the service shutdown steps in the coordinator:
+// the store is closed only after the HTTP server has been drained
+func (a *Archive) RegisterShutdownHooks(c *shutdown.Coordinator) {
+	a.Service.RegisterShutdownHooks(c)
+
+	c.Register(shutdown.CloseStores, "store", func(_ context.Context) error {
+		a.Close()
+		return nil
+	})
+}
+
 // ************************** HTTP Handlers **************************
 
 func (a *Archive) newScore(w http.ResponseWriter, r *http.Request) {
@@ -13,6 +13,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/Pippolo84/go-services-patterns/part1/graceful-shutdown/shutdown"
 	"github.com/Pippolo84/go-services-patterns/part2/threader/internal/model"
 	"github.com/Pippolo84/go-services-patterns/part2/threader/internal/service"
 	"github.com/Pippolo84/go-services-patterns/part2/threader/internal/validate"
@@ -29,8 +30,16 @@ const (
 	// SvcCooldownTimeout is the maximum cooldown time before forcing the shutdown
 	SvcCooldownTimeout time.Duration = 10 * time.Second
 
+	// SvcWatchdogMargin is the extra time given to the shutdown before
+	// dumping the goroutines and exiting hard
+	SvcWatchdogMargin time.Duration = 5 * time.Second
+
 	// MaxRequestBodySize is the maximum size, in bytes, if a request body
 	MaxRequestBodySize int64 = 1 << 20
+
+	// ArchiveTimeout is the timeout of the calls to the archive
+	// when the caller didn't set a deadline
+	ArchiveTimeout time.Duration = 1 * time.Second
 )
 
 func main() {
@@ -39,7 +48,6 @@ func main() {
 	if err := broker.Init(); err != nil {
 		log.Fatalf("initialization error: %v\n", err)
 	}
-	defer broker.Close()
 
 	errs := broker.Run()
 
@@ -58,15 +66,19 @@ func main() {
 	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), SvcCooldownTimeout)
 	defer cancelShutdown()
 
-	var stopWg sync.WaitGroup
-	stopWg.Add(1)
+	coordinator := shutdown.NewCoordinator()
+	broker.RegisterShutdownHooks(coordinator)
 
-	if err := broker.Shutdown(shutdownCtx, &stopWg); err != nil {
+	// if the shutdown hangs, collect some evidence and exit
+	watchdog := shutdown.NewWatchdog(SvcCooldownTimeout+SvcWatchdogMargin, os.Stderr)
+	watchdog.AddDumper("in-flight requests", broker.DumpInFlight)
+	watchdog.Start()
+	defer watchdog.Stop()
+
+	// cleanup everything, phase by phase
+	if err := coordinator.Shutdown(shutdownCtx); err != nil {
 		log.Println(err)
 	}
-
-	// wait for service to cleanup everything
-	stopWg.Wait()
 }
 
 // Broker represents our specific service
@@ -110,6 +122,17 @@ func (b *Broker) Close() {
 	b.store.Close()
 }
 
+// RegisterShutdownHooks registers the service shutdown steps in the coordinator:
+// the store is closed only after the HTTP server has been drained
+func (b *Broker) RegisterShutdownHooks(c *shutdown.Coordinator) {
+	b.Service.RegisterShutdownHooks(c)
+
+	c.Register(shutdown.CloseStores, "store", func(_ context.Context) error {
+		b.Close()
+		return nil
+	})
+}
+
 // ************************** HTTP Handlers **************************
 
 func (b *Broker) threads(w http.ResponseWriter, r *http.Request) {
@@ -233,12 +256,13 @@ func (b *Broker) newMessage(w http.ResponseWriter, r *http.Request) {
 	m.Timestamp = time.Now().Format(time.RFC3339)
 	m.Votes = 0
 
-	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	// use the budget left by the caller, up to ArchiveTimeout
+	ctx, cancel := service.WithBudget(r.Context(), ArchiveTimeout)
 	defer cancel()
 
 	resp, err := b.updateScore(ctx, m.ThreadID, m.ID, m.Votes)
 	if err != nil {
-		if errors.Is(err, context.Canceled) {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 			http.Error(w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
 		} else {
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
@@ -294,12 +318,13 @@ func (b *Broker) upvote(w http.ResponseWriter, r *http.Request) {
 
 	m.Votes++
 
-	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
+	// use the budget left by the caller, up to ArchiveTimeout
+	ctx, cancel := service.WithBudget(r.Context(), ArchiveTimeout)
 	defer cancel()
 
 	resp, err := b.updateScore(ctx, tid, mid, m.Votes)
 	if err != nil {
-		if errors.Is(err, context.Canceled) {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 			http.Error(w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
 		} else {
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
@@ -328,6 +353,7 @@ func (b *Broker) updateScore(ctx context.Context, tid, mid string, score int) (*
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	service.PropagateDeadline(req)
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/Pippolo84/go-services-patterns/part2/threader/internal/model"
+	"github.com/Pippolo84/go-services-patterns/part2/threader/internal/service"
 	"github.com/segmentio/ksuid"
 	"github.com/spf13/cobra"
 )
@@ -121,6 +122,10 @@ func thread(tid, topic string) error {
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+
+	// tell the broker how long we are going to wait
+	service.PropagateDeadline(req)
+
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
@@ -165,6 +170,10 @@ func post(tid, text string) error {
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+
+	// tell the broker how long we are going to wait
+	service.PropagateDeadline(req)
+
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
@@ -199,6 +208,9 @@ func read(tid string) error {
 		return err
 	}
 
+	// tell the broker how long we are going to wait
+	service.PropagateDeadline(req)
+
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
@@ -232,6 +244,9 @@ func readAll() error {
 		return err
 	}
 
+	// tell the broker how long we are going to wait
+	service.PropagateDeadline(req)
+
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
@@ -267,6 +282,9 @@ func upvote(tid, mid string) error {
 		return err
 	}
 
+	// tell the broker how long we are going to wait
+	service.PropagateDeadline(req)
+
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
@@ -3,7 +3,11 @@ module github.com/Pippolo84/go-services-patterns/part2/threader
 go 1.15
 
 require (
+	github.com/Pippolo84/go-services-patterns/part1/graceful-shutdown v0.0.0-00010101000000-000000000000
 	github.com/gorilla/mux v1.8.0
 	github.com/segmentio/ksuid v1.0.3
 	github.com/spf13/cobra v1.1.1
 )
+
+// the shutdown coordinator and the socket activation are shared with the graceful server
+replace github.com/Pippolo84/go-services-patterns/part1/graceful-shutdown => ../../part1/graceful-shutdown
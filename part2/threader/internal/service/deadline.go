@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// TimeoutHeader carries the time left to the caller deadline, in milliseconds
+	TimeoutHeader string = "X-Request-Timeout"
+	// DeadlineHeader carries the absolute caller deadline, in RFC 3339 format
+	DeadlineHeader string = "X-Request-Deadline"
+
+	// DefaultMinBudget is the minimum time left to the caller deadline
+	// to start serving a request
+	DefaultMinBudget time.Duration = 10 * time.Millisecond
+
+	// BudgetHeadroom is the time reserved, before the caller deadline,
+	// to handle the result of an outbound call and respond
+	BudgetHeadroom time.Duration = 10 * time.Millisecond
+)
+
+// callerDeadline returns the deadline of the caller, read from the request headers
+// The relative timeout is preferred, since it doesn't depend on the clocks
+// of the caller and the service being in sync
+func callerDeadline(r *http.Request) (time.Time, bool) {
+	if v := r.Header.Get(TimeoutHeader); v != "" {
+		if ms, err := strconv.ParseInt(v, 10, 64); err == nil && ms >= 0 {
+			return time.Now().Add(time.Duration(ms) * time.Millisecond), true
+		}
+	}
+
+	if v := r.Header.Get(DeadlineHeader); v != "" {
+		if deadline, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return deadline, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// honorDeadline wraps next to apply the caller deadline to the request context
+// A request with less than minBudget left is rejected with a 504 Gateway Timeout
+// without doing any work: the caller will give up on it anyway
+// A nil next is http.DefaultServeMux, as for the http.Server
+func honorDeadline(minBudget time.Duration, next http.Handler) http.Handler {
+	if next == nil {
+		next = http.DefaultServeMux
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok := callerDeadline(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if time.Until(deadline) < minBudget {
+			http.Error(w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
+			return
+		}
+
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// PropagateDeadline sets the headers carrying the deadline of the request
+// context, so that the downstream service knows the time left to respond
+// It does nothing if the context has no deadline
+func PropagateDeadline(req *http.Request) {
+	deadline, ok := req.Context().Deadline()
+	if !ok {
+		return
+	}
+
+	left := time.Until(deadline)
+	if left < 0 {
+		left = 0
+	}
+
+	req.Header.Set(TimeoutHeader, strconv.FormatInt(left.Milliseconds(), 10))
+	req.Header.Set(DeadlineHeader, deadline.UTC().Format(time.RFC3339Nano))
+}
+
+// WithBudget returns a context that expires at the earliest between timeout
+// and the deadline inherited from ctx, minus BudgetHeadroom
+// It's meant for outbound calls: they never take longer than timeout, and they
+// give up early enough to leave the caller the time to handle their result
+func WithBudget(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if deadline = deadline.Add(-BudgetHeadroom); deadline.Before(time.Now().Add(timeout)) {
+			return context.WithDeadline(ctx, deadline)
+		}
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHonorDeadline(t *testing.T) {
+	testCases := []struct {
+		name     string
+		headers  map[string]string
+		called   bool
+		deadline bool
+		code     int
+	}{
+		{
+			name:     "no deadline",
+			headers:  map[string]string{},
+			called:   true,
+			deadline: false,
+			code:     http.StatusOK,
+		},
+		{
+			name:     "timeout header",
+			headers:  map[string]string{TimeoutHeader: "2000"},
+			called:   true,
+			deadline: true,
+			code:     http.StatusOK,
+		},
+		{
+			name:     "deadline header",
+			headers:  map[string]string{DeadlineHeader: time.Now().Add(2 * time.Second).UTC().Format(time.RFC3339Nano)},
+			called:   true,
+			deadline: true,
+			code:     http.StatusOK,
+		},
+		{
+			name:    "budget too low",
+			headers: map[string]string{TimeoutHeader: "1"},
+			called:  false,
+			code:    http.StatusGatewayTimeout,
+		},
+		{
+			name:    "deadline expired",
+			headers: map[string]string{DeadlineHeader: time.Now().Add(-time.Second).UTC().Format(time.RFC3339Nano)},
+			called:  false,
+			code:    http.StatusGatewayTimeout,
+		},
+		{
+			name:     "invalid header",
+			headers:  map[string]string{TimeoutHeader: "invalid"},
+			called:   true,
+			deadline: false,
+			code:     http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				called   bool
+				deadline bool
+			)
+			handler := honorDeadline(DefaultMinBudget, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				_, deadline = r.Context().Deadline()
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.code {
+				t.Fatalf("expected status code %d, got %d\n", tc.code, rr.Code)
+			}
+			if called != tc.called {
+				t.Fatalf("expected handler called: %v, got %v\n", tc.called, called)
+			}
+			if deadline != tc.deadline {
+				t.Fatalf("expected request deadline: %v, got %v\n", tc.deadline, deadline)
+			}
+		})
+	}
+}
+
+func TestPropagateDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	out, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	PropagateDeadline(out)
+
+	// the downstream service sees the same budget
+	in := httptest.NewRequest(http.MethodGet, "/", nil)
+	in.Header = out.Header
+
+	deadline, ok := callerDeadline(in)
+	if !ok {
+		t.Fatal("expected a caller deadline")
+	}
+
+	expected, _ := ctx.Deadline()
+	if diff := deadline.Sub(expected); diff < -100*time.Millisecond || diff > 100*time.Millisecond {
+		t.Fatalf("expected deadline %v, got %v\n", expected, deadline)
+	}
+}
+
+func TestWithBudget(t *testing.T) {
+	testCases := []struct {
+		name     string
+		caller   time.Duration
+		timeout  time.Duration
+		expected time.Duration
+	}{
+		{
+			name:     "no caller deadline",
+			timeout:  time.Second,
+			expected: time.Second,
+		},
+		{
+			name:     "caller deadline later than timeout",
+			caller:   2 * time.Second,
+			timeout:  time.Second,
+			expected: time.Second,
+		},
+		{
+			name:     "caller deadline earlier than timeout",
+			caller:   time.Second,
+			timeout:  2 * time.Second,
+			expected: time.Second - BudgetHeadroom,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			parent := context.Background()
+			if tc.caller > 0 {
+				var cancel context.CancelFunc
+				parent, cancel = context.WithTimeout(parent, tc.caller)
+				defer cancel()
+			}
+
+			ctx, cancel := WithBudget(parent, tc.timeout)
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				t.Fatal("expected a deadline")
+			}
+
+			if left := time.Until(deadline); left > tc.expected || left < tc.expected-100*time.Millisecond {
+				t.Fatalf("expected %v left, got %v\n", tc.expected, left)
+			}
+		})
+	}
+}
+
+func TestHonorDeadlineNilHandler(t *testing.T) {
+	http.HandleFunc("/nil-handler", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	// without a handler, the service serves http.DefaultServeMux
+	svc := NewService(Options{})
+
+	rr := httptest.NewRecorder()
+	svc.server.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/nil-handler", nil))
+
+	if rr.Code != http.StatusTeapot {
+		t.Fatalf("expected status code %d, got %d\n", http.StatusTeapot, rr.Code)
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// request holds the information about an in-flight request
+type request struct {
+	route string
+	start time.Time
+}
+
+// inflight is a registry of the requests being served
+type inflight struct {
+	mu   sync.Mutex
+	reqs map[*request]struct{}
+}
+
+func newInflight() *inflight {
+	return &inflight{
+		reqs: make(map[*request]struct{}),
+	}
+}
+
+// track wraps next to register each request while it is being served
+func (i *inflight) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &request{
+			route: r.Method + " " + r.URL.Path,
+			start: time.Now(),
+		}
+
+		i.mu.Lock()
+		i.reqs[req] = struct{}{}
+		i.mu.Unlock()
+
+		defer func() {
+			i.mu.Lock()
+			delete(i.reqs, req)
+			i.mu.Unlock()
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// dump writes the list of the in-flight requests to w
+func (i *inflight) dump(w io.Writer) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if len(i.reqs) == 0 {
+		fmt.Fprintln(w, "no in-flight requests")
+		return
+	}
+
+	for req := range i.reqs {
+		fmt.Fprintf(w, "%s (running for %v)\n", req.route, time.Since(req.start))
+	}
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "service.sock")
+
+	svc := NewService(Options{
+		Addr: "unix:" + sock,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+		Logger: log.New(ioutil.Discard, "", log.LstdFlags),
+	})
+	svcErrs := svc.Run()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", sock)
+			},
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	// wait for the service to listen on the socket
+	var res *http.Response
+	for i := 0; ; i++ {
+		if res, err = client.Get("http://unix/"); err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d\n", http.StatusOK, res.StatusCode)
+	}
+
+	if err := svc.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// svcErrs should have been closed without errors
+	for err := range svcErrs {
+		t.Fatal(err)
+	}
+}
+
+func TestCustomListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := NewService(Options{
+		// never used, the listener takes precedence
+		Addr: "invalid address",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}),
+		Logger:   log.New(ioutil.Discard, "", log.LstdFlags),
+		Listener: ln,
+	})
+	svcErrs := svc.Run()
+
+	res, err := http.Get("http://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status code %d, got %d\n", http.StatusNoContent, res.StatusCode)
+	}
+
+	if err := svc.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for err := range svcErrs {
+		t.Fatal(err)
+	}
+}
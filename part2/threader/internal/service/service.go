@@ -3,11 +3,15 @@ package service
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"sync"
 	"time"
+
+	"github.com/Pippolo84/go-services-patterns/part1/graceful-shutdown/graceful"
+	"github.com/Pippolo84/go-services-patterns/part1/graceful-shutdown/shutdown"
 )
 
 // Options holds all the configuration options for the Service
@@ -21,12 +25,22 @@ type Options struct {
 
 	Handler http.Handler
 	Logger  *log.Logger
+
+	// MinBudget is the minimum time left to the caller deadline to serve a request
+	// If zero, DefaultMinBudget is used
+	MinBudget time.Duration
+
+	// Listener, if not nil, is used to accept the connections instead of
+	// listening on Addr (e.g. a Unix domain socket or an in-memory listener)
+	Listener net.Listener
 }
 
 // Service is the type of a rss service
 type Service struct {
-	name   string
-	server *http.Server
+	name     string
+	server   *http.Server
+	listener net.Listener
+	inflight *inflight
 
 	Logger *log.Logger
 }
@@ -34,15 +48,24 @@ type Service struct {
 // NewService builds a new Service using the config
 // options passed as a parameter
 func NewService(opts Options) *Service {
+	inflight := newInflight()
+
+	minBudget := opts.MinBudget
+	if minBudget == 0 {
+		minBudget = DefaultMinBudget
+	}
+
 	return &Service{
 		server: &http.Server{
 			Addr:         opts.Addr,
-			Handler:      opts.Handler,
+			Handler:      inflight.track(honorDeadline(minBudget, opts.Handler)),
 			ReadTimeout:  opts.ReadTimeout,
 			WriteTimeout: opts.WriteTimeout,
 			IdleTimeout:  opts.IdleTimeout,
 		},
-		Logger: opts.Logger,
+		listener: opts.Listener,
+		inflight: inflight,
+		Logger:   opts.Logger,
 	}
 }
 
@@ -59,34 +82,50 @@ const (
 // The mandatyory parameters are the name of the service, the address on which it will expose itself
 // and the http handler
 func NewDefaultService(name, addr string, handler http.Handler) *Service {
+	inflight := newInflight()
+
 	return &Service{
 		name: name,
 		server: &http.Server{
 			Addr:         addr,
-			Handler:      handler,
+			Handler:      inflight.track(honorDeadline(DefaultMinBudget, handler)),
 			ReadTimeout:  SrvReadTimeout,
 			WriteTimeout: SrvWriteTimeout,
 			IdleTimeout:  SrvIdleTimeout,
 		},
-		Logger: log.New(os.Stdout, fmt.Sprintf("%s: ", name), log.LstdFlags),
+		inflight: inflight,
+		Logger:   log.New(os.Stdout, fmt.Sprintf("%s: ", name), log.LstdFlags),
 	}
 }
 
 // Run starts the service
-// is about to call ListenAndServer
+// It listens on the service address, that is either a TCP address or the path
+// of a Unix domain socket prefixed with "unix:". If the process has been socket
+// activated, the socket passed by the service manager is used instead, as well
+// as the listener specified in the Options, if any.
 // It returns a channel where all the errors are forwarded
 func (svc *Service) Run() <-chan error {
 	svcErrs := make(chan error)
 
 	go func() {
-		svc.Logger.Printf("running on %s", svc.server.Addr)
-
 		defer func() {
 			close(svcErrs)
 			svc.Logger.Println("run stopped")
 		}()
 
-		if err := svc.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		ln := svc.listener
+		if ln == nil {
+			var err error
+			if ln, err = graceful.Listen(svc.server.Addr); err != nil {
+				svc.Logger.Printf("run error: %v\n", err)
+				svcErrs <- err
+				return
+			}
+		}
+
+		svc.Logger.Printf("running on %s", ln.Addr())
+
+		if err := svc.server.Serve(ln); err != nil && err != http.ErrServerClosed {
 			svc.Logger.Printf("run error: %v\n", err)
 			svcErrs <- err
 		}
@@ -95,15 +134,22 @@ func (svc *Service) Run() <-chan error {
 	return svcErrs
 }
 
+// DumpInFlight writes the list of the in-flight requests to w
+func (svc *Service) DumpInFlight(w io.Writer) {
+	svc.inflight.dump(w)
+}
+
+// RegisterShutdownHooks registers the drain of the underlying HTTP server
+// in the shutdown coordinator
+func (svc *Service) RegisterShutdownHooks(c *shutdown.Coordinator) {
+	c.Register(shutdown.DrainHTTP, "http", svc.Shutdown)
+}
+
 // Shutdown shuts down the service
 // It takes a context that it's passed to the underling HTTP server to control the shutdown process
-// and a *sync.WaitGroup to signal when the shutdown process is ended
-func (svc *Service) Shutdown(ctx context.Context, wg *sync.WaitGroup) error {
+func (svc *Service) Shutdown(ctx context.Context) error {
 	svc.Logger.Println("shutdown")
-	defer func() {
-		wg.Done()
-		svc.Logger.Println("bye")
-	}()
+	defer svc.Logger.Println("bye")
 
 	return svc.server.Shutdown(ctx)
 }